@@ -0,0 +1,14 @@
+//go:build static && system_libgit2
+// +build static,system_libgit2
+
+package git2goutil
+
+import (
+	git "github.com/libgit2/git2go/v33"
+)
+
+// OpenRepository opens the repository at path via libgit2. The returned
+// repository must be freed by the caller.
+func OpenRepository(path string) (*git.Repository, error) {
+	return git.OpenRepository(path)
+}
@@ -0,0 +1,39 @@
+//go:build static && system_libgit2
+// +build static,system_libgit2
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// subcommand is implemented by each of the gitaly-git2go-v14 subcommands.
+// Requests are read as a gob-encoded stream on r, and the gob-encoded
+// response or error is written to w.
+type subcommand interface {
+	Run(ctx context.Context, r io.Reader, w io.Writer) error
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: gitaly-git2go-v14 <subcommand>")
+		os.Exit(1)
+	}
+
+	var cmd subcommand
+	switch os.Args[1] {
+	case "merge":
+		cmd = &mergeSubcommand{}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown subcommand: %q\n", os.Args[1])
+		os.Exit(1)
+	}
+
+	if err := cmd.Run(context.Background(), os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
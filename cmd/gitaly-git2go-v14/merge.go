@@ -0,0 +1,493 @@
+//go:build static && system_libgit2
+// +build static,system_libgit2
+
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"flag"
+	"fmt"
+	"io"
+
+	git "github.com/libgit2/git2go/v33"
+	"gitlab.com/gitlab-org/gitaly/v14/cmd/gitaly-git2go-v14/git2goutil"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/git2go"
+)
+
+type mergeSubcommand struct {
+	repository string
+}
+
+func (cmd *mergeSubcommand) Flags() *flag.FlagSet {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	flags.StringVar(&cmd.repository, "repository", "", "path to the repository to merge in")
+	return flags
+}
+
+func (cmd *mergeSubcommand) Run(ctx context.Context, r io.Reader, w io.Writer) error {
+	var request git2go.MergeCommand
+	if err := gob.NewDecoder(r).Decode(&request); err != nil {
+		return fmt.Errorf("decoding request: %w", err)
+	}
+
+	result, err := cmd.merge(ctx, request)
+	if err != nil {
+		// The error is encoded as an interface value so that the caller can
+		// decode it back into its registered concrete type. Returning it
+		// as well makes the process exit non-zero, which is what tells the
+		// caller to decode an error instead of a result.
+		_ = gob.NewEncoder(w).Encode(&err)
+		return err
+	}
+
+	return gob.NewEncoder(w).Encode(result)
+}
+
+func (cmd *mergeSubcommand) merge(ctx context.Context, request git2go.MergeCommand) (git2go.MergeResult, error) {
+	repo, err := git2goutil.OpenRepository(request.Repository)
+	if err != nil {
+		return git2go.MergeResult{}, fmt.Errorf("could not open repository: %w", err)
+	}
+	defer repo.Free()
+
+	ours, err := lookupCommit(repo, request.Ours)
+	if err != nil {
+		return git2go.MergeResult{}, fmt.Errorf("could not lookup ours: %w", err)
+	}
+	defer ours.Free()
+
+	if len(request.TheirsRefs) > 0 {
+		return cmd.octopusMerge(repo, ours, request)
+	}
+
+	theirs, err := lookupCommit(repo, request.Theirs)
+	if err != nil {
+		return git2go.MergeResult{}, fmt.Errorf("could not lookup theirs: %w", err)
+	}
+	defer theirs.Free()
+
+	mergeOpts, err := git.DefaultMergeOptions()
+	if err != nil {
+		return git2go.MergeResult{}, fmt.Errorf("could not create merge options: %w", err)
+	}
+	mergeOpts.RecursionLimit = recursionLimit(request)
+
+	index, err := cmd.mergeCommits(repo, ours, theirs, &mergeOpts, request.AllowUnrelatedHistories, request.VirtualBaseStrategy)
+	if err != nil {
+		return git2go.MergeResult{}, err
+	}
+	defer index.Free()
+
+	if index.HasConflicts() {
+		var conflictingFiles []string
+		var conflicts []git2go.MergeConflict
+		conflictIterator, err := index.ConflictIterator()
+		if err != nil {
+			return git2go.MergeResult{}, fmt.Errorf("could not create conflict iterator: %w", err)
+		}
+
+		for {
+			conflict, err := conflictIterator.Next()
+			if git.IsErrorCode(err, git.ErrIterOver) {
+				break
+			}
+			if err != nil {
+				return git2go.MergeResult{}, fmt.Errorf("could not iterate conflicts: %w", err)
+			}
+
+			path := ""
+			if conflict.Our != nil {
+				path = conflict.Our.Path
+			} else if conflict.Their != nil {
+				path = conflict.Their.Path
+			}
+			conflictingFiles = append(conflictingFiles, path)
+
+			if request.ConflictDetails {
+				mergeConflict, err := cmd.buildMergeConflict(repo, path, conflict)
+				if err != nil {
+					return git2go.MergeResult{}, fmt.Errorf("could not build conflict details: %w", err)
+				}
+				conflicts = append(conflicts, mergeConflict)
+			}
+		}
+
+		return git2go.MergeResult{}, git2go.ConflictingFilesError{
+			ConflictingFiles: conflictingFiles,
+			Conflicts:        conflicts,
+		}
+	}
+
+	treeOid, err := index.WriteTreeTo(repo)
+	if err != nil {
+		return git2go.MergeResult{}, fmt.Errorf("could not write tree: %w", err)
+	}
+
+	if request.DryRun {
+		return git2go.MergeResult{TreeID: treeOid.String()}, nil
+	}
+
+	tree, err := repo.LookupTree(treeOid)
+	if err != nil {
+		return git2go.MergeResult{}, fmt.Errorf("could not lookup tree: %w", err)
+	}
+	defer tree.Free()
+
+	author := &git.Signature{Name: request.AuthorName, Email: request.AuthorMail, When: request.AuthorDate}
+
+	committer := author
+	if request.CommitterName != "" {
+		committer = &git.Signature{Name: request.CommitterName, Email: request.CommitterMail, When: request.CommitterDate}
+	}
+
+	parents := []*git.Commit{ours, theirs}
+	if request.Squash {
+		parents = []*git.Commit{ours}
+	}
+
+	commitOid, err := repo.CreateCommit("", author, committer, request.Message, tree, parents...)
+	if err != nil {
+		return git2go.MergeResult{}, fmt.Errorf("could not create commit: %w", err)
+	}
+
+	return git2go.MergeResult{CommitID: commitOid.String()}, nil
+}
+
+// octopusMerge folds each of request.TheirsRefs onto ours in turn,
+// producing a single commit with len(TheirsRefs)+1 parents. Octopus
+// semantics forbid conflict resolution: the first pairwise conflict aborts
+// the whole merge with a ConflictingFilesError naming the offending head.
+func (cmd *mergeSubcommand) octopusMerge(repo *git.Repository, ours *git.Commit, request git2go.MergeCommand) (git2go.MergeResult, error) {
+	mergeOpts, err := git.DefaultMergeOptions()
+	if err != nil {
+		return git2go.MergeResult{}, fmt.Errorf("could not create merge options: %w", err)
+	}
+	mergeOpts.RecursionLimit = recursionLimit(request)
+
+	runningTree, err := ours.Tree()
+	if err != nil {
+		return git2go.MergeResult{}, fmt.Errorf("could not resolve ours tree: %w", err)
+	}
+	// runningTree is reassigned to the result of each fold below, so this
+	// must read its value at Free time rather than capture the initial
+	// tree now: a plain "defer runningTree.Free()" would only ever free
+	// the first tree and, once the loop starts freeing superseded trees
+	// itself, would double-free it.
+	defer func() { runningTree.Free() }()
+
+	parents := []*git.Commit{ours}
+
+	for _, head := range request.TheirsRefs {
+		theirs, err := lookupCommit(repo, head)
+		if err != nil {
+			return git2go.MergeResult{}, fmt.Errorf("could not lookup octopus head %q: %w", head, err)
+		}
+		defer theirs.Free()
+
+		theirsTree, err := theirs.Tree()
+		if err != nil {
+			return git2go.MergeResult{}, fmt.Errorf("could not resolve octopus head %q tree: %w", head, err)
+		}
+		defer theirsTree.Free()
+
+		baseOid, err := repo.MergeBase(ours.Id(), theirs.Id())
+		if err != nil {
+			return git2go.MergeResult{}, git2go.UnrelatedHistoriesError{Ours: ours.Id().String(), Theirs: theirs.Id().String()}
+		}
+
+		baseCommit, err := repo.LookupCommit(baseOid)
+		if err != nil {
+			return git2go.MergeResult{}, fmt.Errorf("could not lookup merge base for octopus head %q: %w", head, err)
+		}
+		defer baseCommit.Free()
+
+		baseTree, err := baseCommit.Tree()
+		if err != nil {
+			return git2go.MergeResult{}, fmt.Errorf("could not resolve merge base tree for octopus head %q: %w", head, err)
+		}
+		defer baseTree.Free()
+
+		index, err := repo.MergeTrees(baseTree, runningTree, theirsTree, &mergeOpts)
+		if err != nil {
+			return git2go.MergeResult{}, fmt.Errorf("could not merge octopus head %q: %w", head, err)
+		}
+		defer index.Free()
+
+		if index.HasConflicts() {
+			conflictingFiles, err := conflictingPaths(index)
+			if err != nil {
+				return git2go.MergeResult{}, err
+			}
+
+			return git2go.MergeResult{}, git2go.ConflictingFilesError{
+				ConflictingFiles: conflictingFiles,
+				TheirsHead:       head,
+			}
+		}
+
+		treeOid, err := index.WriteTreeTo(repo)
+		if err != nil {
+			return git2go.MergeResult{}, fmt.Errorf("could not write tree for octopus head %q: %w", head, err)
+		}
+
+		runningTree.Free()
+		runningTree, err = repo.LookupTree(treeOid)
+		if err != nil {
+			return git2go.MergeResult{}, fmt.Errorf("could not lookup tree for octopus head %q: %w", head, err)
+		}
+
+		parents = append(parents, theirs)
+	}
+
+	if request.DryRun {
+		return git2go.MergeResult{TreeID: runningTree.Id().String()}, nil
+	}
+
+	author := &git.Signature{Name: request.AuthorName, Email: request.AuthorMail, When: request.AuthorDate}
+
+	committer := author
+	if request.CommitterName != "" {
+		committer = &git.Signature{Name: request.CommitterName, Email: request.CommitterMail, When: request.CommitterDate}
+	}
+
+	commitOid, err := repo.CreateCommit("", author, committer, request.Message, runningTree, parents...)
+	if err != nil {
+		return git2go.MergeResult{}, fmt.Errorf("could not create octopus merge commit: %w", err)
+	}
+
+	return git2go.MergeResult{CommitID: commitOid.String()}, nil
+}
+
+// conflictingPaths returns the paths of all conflicting entries in index.
+func conflictingPaths(index *git.Index) ([]string, error) {
+	var conflictingFiles []string
+
+	conflictIterator, err := index.ConflictIterator()
+	if err != nil {
+		return nil, fmt.Errorf("could not create conflict iterator: %w", err)
+	}
+
+	for {
+		conflict, err := conflictIterator.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not iterate conflicts: %w", err)
+		}
+
+		if conflict.Our != nil {
+			conflictingFiles = append(conflictingFiles, conflict.Our.Path)
+		} else if conflict.Their != nil {
+			conflictingFiles = append(conflictingFiles, conflict.Their.Path)
+		}
+	}
+
+	return conflictingFiles, nil
+}
+
+// mergeCommits merges theirs into ours, resolving against an empty tree
+// instead of failing when the two commits share no merge base and the
+// caller has opted into AllowUnrelatedHistories. When ours and theirs have
+// more than one merge base, virtualBaseStrategy decides whether libgit2 is
+// left to compute a virtual base (the default), a single candidate is
+// picked deterministically, or the ambiguity is surfaced to the caller.
+func (cmd *mergeSubcommand) mergeCommits(
+	repo *git.Repository,
+	ours, theirs *git.Commit,
+	mergeOpts *git.MergeOptions,
+	allowUnrelatedHistories bool,
+	virtualBaseStrategy git2go.VirtualBaseStrategy,
+) (*git.Index, error) {
+	bases, err := repo.MergeBases(ours.Id(), theirs.Id())
+	if err == nil && len(bases) > 0 {
+		if len(bases) > 1 {
+			switch virtualBaseStrategy {
+			case git2go.VirtualBaseFailOnMultiple:
+				candidates := make([]string, len(bases))
+				for i, base := range bases {
+					candidates[i] = base.String()
+				}
+
+				return nil, git2go.AmbiguousMergeBaseError{
+					Ours:       ours.Id().String(),
+					Theirs:     theirs.Id().String(),
+					Candidates: candidates,
+				}
+			case git2go.VirtualBasePickFirst:
+				return cmd.mergeWithBase(repo, bases[0], ours, theirs, mergeOpts)
+			}
+		}
+
+		return repo.MergeCommits(ours, theirs, mergeOpts)
+	}
+
+	if !allowUnrelatedHistories {
+		return nil, git2go.UnrelatedHistoriesError{Ours: ours.Id().String(), Theirs: theirs.Id().String()}
+	}
+
+	emptyTree, err := repo.LookupTree(&git.Oid{})
+	if err != nil {
+		// libgit2 has no canonical empty tree helper; callers merge
+		// against a freshly created empty tree instead.
+		emptyTreeOid, treeErr := newEmptyTree(repo)
+		if treeErr != nil {
+			return nil, fmt.Errorf("could not create empty tree: %w", treeErr)
+		}
+
+		emptyTree, err = repo.LookupTree(emptyTreeOid)
+		if err != nil {
+			return nil, fmt.Errorf("could not lookup empty tree: %w", err)
+		}
+	}
+	defer emptyTree.Free()
+
+	oursTree, err := ours.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve ours tree: %w", err)
+	}
+	defer oursTree.Free()
+
+	theirsTree, err := theirs.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve theirs tree: %w", err)
+	}
+	defer theirsTree.Free()
+
+	return repo.MergeTrees(emptyTree, oursTree, theirsTree, mergeOpts)
+}
+
+// mergeWithBase merges ours and theirs against a single, explicitly chosen
+// base commit rather than letting libgit2 compute a virtual one.
+func (cmd *mergeSubcommand) mergeWithBase(repo *git.Repository, base *git.Oid, ours, theirs *git.Commit, mergeOpts *git.MergeOptions) (*git.Index, error) {
+	baseCommit, err := repo.LookupCommit(base)
+	if err != nil {
+		return nil, fmt.Errorf("could not lookup merge base: %w", err)
+	}
+	defer baseCommit.Free()
+
+	baseTree, err := baseCommit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve merge base tree: %w", err)
+	}
+	defer baseTree.Free()
+
+	oursTree, err := ours.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve ours tree: %w", err)
+	}
+	defer oursTree.Free()
+
+	theirsTree, err := theirs.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve theirs tree: %w", err)
+	}
+	defer theirsTree.Free()
+
+	return repo.MergeTrees(baseTree, oursTree, theirsTree, mergeOpts)
+}
+
+// recursionLimit returns request's configured recursion limit, falling
+// back to git2go.MergeRecursionLimit when unset.
+func recursionLimit(request git2go.MergeCommand) uint {
+	if request.RecursionLimit > 0 {
+		return uint(request.RecursionLimit)
+	}
+
+	return git2go.MergeRecursionLimit
+}
+
+func newEmptyTree(repo *git.Repository) (*git.Oid, error) {
+	index, err := git.NewIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer index.Free()
+
+	return index.WriteTreeTo(repo)
+}
+
+// buildMergeConflict resolves the three merge-index stages of conflict into
+// a git2go.MergeConflict, including the merged file with conflict markers
+// where libgit2 is able to produce one.
+func (cmd *mergeSubcommand) buildMergeConflict(repo *git.Repository, path string, conflict git.IndexConflict) (git2go.MergeConflict, error) {
+	mergeConflict := git2go.MergeConflict{
+		Path:     path,
+		Ancestor: conflictEntry(conflict.Ancestor),
+		Our:      conflictEntry(conflict.Our),
+		Their:    conflictEntry(conflict.Their),
+	}
+
+	ancestorInput, err := mergeFileInput(repo, conflict.Ancestor)
+	if err != nil {
+		return git2go.MergeConflict{}, err
+	}
+	ourInput, err := mergeFileInput(repo, conflict.Our)
+	if err != nil {
+		return git2go.MergeConflict{}, err
+	}
+	theirInput, err := mergeFileInput(repo, conflict.Their)
+	if err != nil {
+		return git2go.MergeConflict{}, err
+	}
+
+	result, err := git.MergeFile(ancestorInput, ourInput, theirInput, &git.MergeFileOptions{
+		Style: git.MergeFileStyleDiff3,
+	})
+	if err != nil {
+		// libgit2 cannot always produce merged contents, e.g. when one
+		// side is binary. The structured stage details are still
+		// useful on their own.
+		return mergeConflict, nil
+	}
+	defer result.Free()
+
+	mergeConflict.Contents = result.Contents
+
+	return mergeConflict, nil
+}
+
+func conflictEntry(entry *git.IndexEntry) git2go.ConflictEntry {
+	if entry == nil {
+		return git2go.ConflictEntry{}
+	}
+
+	return git2go.ConflictEntry{
+		OID:  entry.Id.String(),
+		Mode: uint32(entry.Mode),
+	}
+}
+
+func mergeFileInput(repo *git.Repository, entry *git.IndexEntry) (git.MergeFileInput, error) {
+	if entry == nil {
+		return git.MergeFileInput{}, nil
+	}
+
+	blob, err := repo.LookupBlob(entry.Id)
+	if err != nil {
+		return git.MergeFileInput{}, fmt.Errorf("could not lookup blob %s: %w", entry.Id, err)
+	}
+	defer blob.Free()
+
+	return git.MergeFileInput{
+		Path:     entry.Path,
+		Mode:     uint(entry.Mode),
+		Contents: blob.Contents(),
+	}, nil
+}
+
+func lookupCommit(repo *git.Repository, revision string) (*git.Commit, error) {
+	oid, err := git.NewOid(revision)
+	if err != nil {
+		obj, err := repo.RevparseSingle(revision)
+		if err != nil {
+			return nil, err
+		}
+		defer obj.Free()
+
+		return obj.AsCommit()
+	}
+
+	return repo.LookupCommit(oid)
+}
@@ -81,6 +81,22 @@ func TestMerge_missingArguments(t *testing.T) {
 			request:     git2go.MergeCommand{Repository: repoPath, AuthorName: "Foo", AuthorMail: "foo@example.com", CommitterName: "Bar", CommitterMail: "bar@example.com", Message: "Foo", Theirs: "HEAD", Ours: "HEAD"},
 			expectedErr: "merge: invalid parameters: missing committer date",
 		},
+		// DryRun never writes a commit, so author, committer and message are not required.
+		{
+			desc:        "dry run without repository",
+			request:     git2go.MergeCommand{DryRun: true, Ours: "HEAD", Theirs: "HEAD"},
+			expectedErr: "merge: invalid parameters: missing repository",
+		},
+		{
+			desc:        "dry run without ours",
+			request:     git2go.MergeCommand{Repository: repoPath, DryRun: true, Theirs: "HEAD"},
+			expectedErr: "merge: invalid parameters: missing ours",
+		},
+		{
+			desc:        "dry run without theirs",
+			request:     git2go.MergeCommand{Repository: repoPath, DryRun: true, Ours: "HEAD"},
+			expectedErr: "merge: invalid parameters: missing theirs",
+		},
 	}
 
 	for _, tc := range testcases {
@@ -409,6 +425,301 @@ func TestMerge_squash(t *testing.T) {
 	require.Equal(t, "a\nb\nc\nd\ne", string(blob.Contents()))
 }
 
+func TestMerge_dryRun(t *testing.T) {
+	t.Parallel()
+	ctx := testhelper.Context(t)
+
+	t.Run("clean merge reports tree without committing", func(t *testing.T) {
+		cfg, repoProto, repoPath := testcfg.BuildWithRepo(t)
+		testcfg.BuildGitalyGit2Go(t, cfg)
+		executor := buildExecutor(t, cfg)
+
+		base := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{nil}, map[string]string{"1": "foo", "2": "bar"})
+		ours := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"1": "foo", "2": "modified"})
+		theirs := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"1": "modified", "2": "bar"})
+
+		refsBefore := gittest.Exec(t, cfg, "-C", repoPath, "for-each-ref")
+
+		response, err := executor.Merge(ctx, repoProto, git2go.MergeCommand{
+			Repository: repoPath,
+			Ours:       ours.String(),
+			Theirs:     theirs.String(),
+			DryRun:     true,
+		})
+		require.NoError(t, err)
+		require.Empty(t, response.CommitID)
+		require.NotEmpty(t, response.TreeID)
+
+		repo, err := git2goutil.OpenRepository(repoPath)
+		require.NoError(t, err)
+		defer repo.Free()
+
+		treeOid, err := git.NewOid(response.TreeID)
+		require.NoError(t, err)
+
+		tree, err := repo.LookupTree(treeOid)
+		require.NoError(t, err)
+
+		for name, contents := range map[string]string{"1": "modified", "2": "modified"} {
+			entry := tree.EntryByName(name)
+			require.NotNil(t, entry)
+
+			blob, err := repo.LookupBlob(entry.Id)
+			require.NoError(t, err)
+			require.Equal(t, []byte(contents), blob.Contents())
+		}
+
+		refsAfter := gittest.Exec(t, cfg, "-C", repoPath, "for-each-ref")
+		require.Equal(t, refsBefore, refsAfter, "dry run must not create a commit or any ref")
+	})
+
+	t.Run("conflicting merge reports conflicts without committing", func(t *testing.T) {
+		cfg, repoProto, repoPath := testcfg.BuildWithRepo(t)
+		testcfg.BuildGitalyGit2Go(t, cfg)
+		executor := buildExecutor(t, cfg)
+
+		base := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{nil}, map[string]string{"1": "foo"})
+		ours := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"1": "bar"})
+		theirs := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"1": "qux"})
+
+		_, err := executor.Merge(ctx, repoProto, git2go.MergeCommand{
+			Repository: repoPath,
+			Ours:       ours.String(),
+			Theirs:     theirs.String(),
+			DryRun:     true,
+		})
+		require.Equal(t, fmt.Errorf("merge: %w", git2go.ConflictingFilesError{
+			ConflictingFiles: []string{"1"},
+		}), err)
+	})
+}
+
+func TestMerge_octopus(t *testing.T) {
+	t.Parallel()
+	ctx := testhelper.Context(t)
+
+	authorDate := time.Date(2020, 7, 30, 7, 45, 50, 0, time.FixedZone("UTC+2", +2*60*60))
+
+	t.Run("three-way octopus succeeds", func(t *testing.T) {
+		cfg, repoProto, repoPath := testcfg.BuildWithRepo(t)
+		testcfg.BuildGitalyGit2Go(t, cfg)
+		executor := buildExecutor(t, cfg)
+
+		base := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{nil}, map[string]string{"1": "base", "2": "base"})
+		ours := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"1": "ours", "2": "base"})
+		theirs1 := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"1": "base", "2": "theirs1"})
+
+		response, err := executor.Merge(ctx, repoProto, git2go.MergeCommand{
+			Repository: repoPath,
+			AuthorName: "John Doe",
+			AuthorMail: "john.doe@example.com",
+			AuthorDate: authorDate,
+			Message:    "Octopus merge message",
+			Ours:       ours.String(),
+			TheirsRefs: []string{theirs1.String()},
+		})
+		require.NoError(t, err)
+
+		repo, err := git2goutil.OpenRepository(repoPath)
+		require.NoError(t, err)
+		defer repo.Free()
+
+		commitOid, err := git.NewOid(response.CommitID)
+		require.NoError(t, err)
+
+		commit, err := repo.LookupCommit(commitOid)
+		require.NoError(t, err)
+
+		require.Equal(t, uint(2), commit.ParentCount())
+		require.Equal(t, ours, commit.ParentId(0))
+		require.Equal(t, theirs1, commit.ParentId(1))
+	})
+
+	t.Run("four-way octopus succeeds", func(t *testing.T) {
+		cfg, repoProto, repoPath := testcfg.BuildWithRepo(t)
+		testcfg.BuildGitalyGit2Go(t, cfg)
+		executor := buildExecutor(t, cfg)
+
+		base := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{nil}, map[string]string{"1": "base", "2": "base", "3": "base"})
+		ours := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"1": "ours", "2": "base", "3": "base"})
+		theirs1 := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"1": "base", "2": "theirs1", "3": "base"})
+		theirs2 := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"1": "base", "2": "base", "3": "theirs2"})
+
+		response, err := executor.Merge(ctx, repoProto, git2go.MergeCommand{
+			Repository: repoPath,
+			AuthorName: "John Doe",
+			AuthorMail: "john.doe@example.com",
+			AuthorDate: authorDate,
+			Message:    "Octopus merge message",
+			Ours:       ours.String(),
+			TheirsRefs: []string{theirs1.String(), theirs2.String()},
+		})
+		require.NoError(t, err)
+
+		repo, err := git2goutil.OpenRepository(repoPath)
+		require.NoError(t, err)
+		defer repo.Free()
+
+		commitOid, err := git.NewOid(response.CommitID)
+		require.NoError(t, err)
+
+		commit, err := repo.LookupCommit(commitOid)
+		require.NoError(t, err)
+		require.Equal(t, uint(3), commit.ParentCount())
+
+		tree, err := commit.Tree()
+		require.NoError(t, err)
+		for name, contents := range map[string]string{
+			"1": "ours",
+			"2": "theirs1",
+			"3": "theirs2",
+		} {
+			entry := tree.EntryByName(name)
+			require.NotNil(t, entry)
+
+			blob, err := repo.LookupBlob(entry.Id)
+			require.NoError(t, err)
+			require.Equal(t, []byte(contents), blob.Contents())
+		}
+	})
+
+	t.Run("four-way octopus fails fast on conflict", func(t *testing.T) {
+		cfg, repoProto, repoPath := testcfg.BuildWithRepo(t)
+		testcfg.BuildGitalyGit2Go(t, cfg)
+		executor := buildExecutor(t, cfg)
+
+		base := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{nil}, map[string]string{"1": "base"})
+		ours := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"1": "ours"})
+		theirs1 := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"1": "base"})
+		theirs2 := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"1": "conflicting"})
+
+		_, err := executor.Merge(ctx, repoProto, git2go.MergeCommand{
+			Repository: repoPath,
+			AuthorName: "John Doe",
+			AuthorMail: "john.doe@example.com",
+			AuthorDate: authorDate,
+			Message:    "Octopus merge message",
+			Ours:       ours.String(),
+			TheirsRefs: []string{theirs1.String(), theirs2.String()},
+		})
+
+		var conflictErr git2go.ConflictingFilesError
+		require.ErrorAs(t, err, &conflictErr)
+		require.Equal(t, []string{"1"}, conflictErr.ConflictingFiles)
+		require.Equal(t, theirs2.String(), conflictErr.TheirsHead)
+	})
+}
+
+func TestMerge_conflictDetails(t *testing.T) {
+	t.Parallel()
+	ctx := testhelper.Context(t)
+
+	cfg, repoProto, repoPath := testcfg.BuildWithRepo(t)
+	testcfg.BuildGitalyGit2Go(t, cfg)
+	executor := buildExecutor(t, cfg)
+
+	base := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{nil}, map[string]string{"file": "foo\n"})
+	ours := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"file": "bar\n"})
+	theirs := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{base}, map[string]string{"file": "qux\n"})
+
+	authorDate := time.Date(2020, 7, 30, 7, 45, 50, 0, time.FixedZone("UTC+2", +2*60*60))
+
+	_, err := executor.Merge(ctx, repoProto, git2go.MergeCommand{
+		Repository:      repoPath,
+		AuthorName:      "John Doe",
+		AuthorMail:      "john.doe@example.com",
+		AuthorDate:      authorDate,
+		Message:         "Merge message",
+		Ours:            ours.String(),
+		Theirs:          theirs.String(),
+		ConflictDetails: true,
+	})
+
+	var conflictErr git2go.ConflictingFilesError
+	require.ErrorAs(t, err, &conflictErr)
+	require.Equal(t, []string{"file"}, conflictErr.ConflictingFiles)
+	require.Len(t, conflictErr.Conflicts, 1)
+
+	conflict := conflictErr.Conflicts[0]
+	require.Equal(t, "file", conflict.Path)
+	require.NotEmpty(t, conflict.Ancestor.OID)
+	require.NotEmpty(t, conflict.Our.OID)
+	require.NotEmpty(t, conflict.Their.OID)
+	require.Contains(t, string(conflict.Contents), "<<<<<<<")
+	require.Contains(t, string(conflict.Contents), "bar")
+	require.Contains(t, string(conflict.Contents), "qux")
+}
+
+func TestMerge_unrelatedHistories(t *testing.T) {
+	t.Parallel()
+	ctx := testhelper.Context(t)
+
+	cfg, repoProto, repoPath := testcfg.BuildWithRepo(t)
+	testcfg.BuildGitalyGit2Go(t, cfg)
+	executor := buildExecutor(t, cfg)
+
+	ours := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{nil}, map[string]string{"ours-file": "ours"})
+	theirs := cmdtesthelper.BuildCommit(t, repoPath, []*git.Oid{nil}, map[string]string{"theirs-file": "theirs"})
+
+	authorDate := time.Date(2020, 7, 30, 7, 45, 50, 0, time.FixedZone("UTC+2", +2*60*60))
+
+	mergeCommand := git2go.MergeCommand{
+		Repository: repoPath,
+		AuthorName: "John Doe",
+		AuthorMail: "john.doe@example.com",
+		AuthorDate: authorDate,
+		Message:    "Merge message",
+		Ours:       ours.String(),
+		Theirs:     theirs.String(),
+	}
+
+	t.Run("rejected without AllowUnrelatedHistories", func(t *testing.T) {
+		_, err := executor.Merge(ctx, repoProto, mergeCommand)
+		require.Equal(t, fmt.Errorf("merge: %w", git2go.UnrelatedHistoriesError{
+			Ours:   ours.String(),
+			Theirs: theirs.String(),
+		}), err)
+	})
+
+	t.Run("succeeds with AllowUnrelatedHistories", func(t *testing.T) {
+		mergeCommand := mergeCommand
+		mergeCommand.AllowUnrelatedHistories = true
+
+		response, err := executor.Merge(ctx, repoProto, mergeCommand)
+		require.NoError(t, err)
+
+		repo, err := git2goutil.OpenRepository(repoPath)
+		require.NoError(t, err)
+		defer repo.Free()
+
+		commitOid, err := git.NewOid(response.CommitID)
+		require.NoError(t, err)
+
+		commit, err := repo.LookupCommit(commitOid)
+		require.NoError(t, err)
+
+		require.Equal(t, uint(2), commit.ParentCount())
+		require.Equal(t, ours, commit.ParentId(0))
+		require.Equal(t, theirs, commit.ParentId(1))
+
+		tree, err := commit.Tree()
+		require.NoError(t, err)
+		require.EqualValues(t, 2, tree.EntryCount())
+
+		for name, contents := range map[string]string{
+			"ours-file":   "ours",
+			"theirs-file": "theirs",
+		} {
+			entry := tree.EntryByName(name)
+			require.NotNil(t, entry)
+
+			blob, err := repo.LookupBlob(entry.Id)
+			require.NoError(t, err)
+			require.Equal(t, []byte(contents), blob.Contents())
+		}
+	})
+}
+
 func TestMerge_recursive(t *testing.T) {
 	t.Parallel()
 	ctx := testhelper.Context(t)
@@ -522,4 +833,38 @@ func TestMerge_recursive(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, []byte(contents), blob.Contents())
 	}
+
+	// Raising RecursionLimit past the number of criss-cross patterns
+	// resolves the merge that conflicted at the top of the graph above.
+	response, err = executor.Merge(ctx, repoProto, git2go.MergeCommand{
+		Repository:     repoPath,
+		AuthorName:     "John Doe",
+		AuthorMail:     "john.doe@example.com",
+		AuthorDate:     authorDate,
+		Message:        "Merge message",
+		Ours:           ours[len(ours)-1].String(),
+		Theirs:         theirs[len(theirs)-1].String(),
+		RecursionLimit: git2go.MergeRecursionLimit * 2,
+	})
+	require.NoError(t, err)
+
+	// Asking the merge to refuse to guess a virtual base instead surfaces
+	// the ambiguity to the caller, listing the candidate bases.
+	_, err = executor.Merge(ctx, repoProto, git2go.MergeCommand{
+		Repository:          repoPath,
+		AuthorName:          "John Doe",
+		AuthorMail:          "john.doe@example.com",
+		AuthorDate:          authorDate,
+		Message:             "Merge message",
+		Ours:                ours[len(ours)-1].String(),
+		Theirs:              theirs[len(theirs)-1].String(),
+		VirtualBaseStrategy: git2go.VirtualBaseFailOnMultiple,
+	})
+	require.Error(t, err)
+
+	var ambiguousErr git2go.AmbiguousMergeBaseError
+	require.ErrorAs(t, err, &ambiguousErr)
+	require.Equal(t, ours[len(ours)-1].String(), ambiguousErr.Ours)
+	require.Equal(t, theirs[len(theirs)-1].String(), ambiguousErr.Theirs)
+	require.NotEmpty(t, ambiguousErr.Candidates)
 }
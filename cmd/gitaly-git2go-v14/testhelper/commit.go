@@ -0,0 +1,67 @@
+//go:build static && system_libgit2
+// +build static,system_libgit2
+
+package testhelper
+
+import (
+	"testing"
+
+	git "github.com/libgit2/git2go/v33"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/gitaly/v14/cmd/gitaly-git2go-v14/git2goutil"
+)
+
+// BuildCommit creates a new commit in the repository at repoPath with the
+// given parents and file contents, returning its object ID. A nil entry in
+// parents denotes the repository's initial, parentless commit.
+func BuildCommit(t *testing.T, repoPath string, parents []*git.Oid, files map[string]string) *git.Oid {
+	t.Helper()
+
+	repo, err := git2goutil.OpenRepository(repoPath)
+	require.NoError(t, err)
+	defer repo.Free()
+
+	odb, err := repo.Odb()
+	require.NoError(t, err)
+
+	index, err := git.NewIndex()
+	require.NoError(t, err)
+
+	for name, contents := range files {
+		oid, err := odb.Write([]byte(contents), git.ObjectBlob)
+		require.NoError(t, err)
+
+		require.NoError(t, index.Add(&git.IndexEntry{
+			Mode: git.FilemodeBlob,
+			Oid:  oid,
+			Path: name,
+		}))
+	}
+
+	treeOid, err := index.WriteTreeTo(repo)
+	require.NoError(t, err)
+
+	tree, err := repo.LookupTree(treeOid)
+	require.NoError(t, err)
+	defer tree.Free()
+
+	var parentCommits []*git.Commit
+	for _, parent := range parents {
+		if parent == nil {
+			continue
+		}
+
+		commit, err := repo.LookupCommit(parent)
+		require.NoError(t, err)
+		defer commit.Free()
+
+		parentCommits = append(parentCommits, commit)
+	}
+
+	signature := &git.Signature{Name: "Test Author", Email: "author@example.com"}
+
+	commitOid, err := repo.CreateCommit("", signature, signature, "commit", tree, parentCommits...)
+	require.NoError(t, err)
+
+	return commitOid
+}
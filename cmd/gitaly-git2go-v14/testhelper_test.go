@@ -0,0 +1,23 @@
+//go:build static && system_libgit2
+// +build static,system_libgit2
+
+package main
+
+import (
+	"testing"
+
+	"gitlab.com/gitlab-org/gitaly/v14/internal/git2go"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/gitaly/config"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/testhelper"
+)
+
+func TestMain(m *testing.M) {
+	testhelper.Run(m)
+}
+
+// buildExecutor returns a git2go.Executor wired up against the
+// gitaly-git2go-v14 binary built for cfg by testcfg.BuildGitalyGit2Go.
+func buildExecutor(t *testing.T, cfg config.Cfg) *git2go.Executor {
+	t.Helper()
+	return git2go.NewExecutor(cfg.BinDir+"/gitaly-git2go-v14", nil)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// hooksFunc implements one of the gitaly-hooks subcommands. args are the
+// subcommand's remaining os.Args, stdin/stdout/stderr are wired to the
+// calling git process. It returns the process exit code.
+type hooksFunc func(args []string) int
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: gitaly-hooks <subcommand>")
+		os.Exit(1)
+	}
+
+	// "proc-receive" is deliberately not registered here yet: procReceive
+	// (see proc_receive.go) only implements the client half of
+	// HookService.ProcReceiveHook. No internal/gitaly/service/hook package
+	// exists in this tree to implement the server half, so every call
+	// would fail with codes.Unimplemented. Wire it in once that server
+	// implementation lands, rather than presenting receive-pack with a
+	// hook that can never succeed.
+	subcommands := map[string]hooksFunc{}
+
+	cmd, ok := subcommands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown subcommand: %q\n", os.Args[1])
+		os.Exit(1)
+	}
+
+	os.Exit(cmd(os.Args[2:]))
+}
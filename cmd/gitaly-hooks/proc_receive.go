@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"gitlab.com/gitlab-org/gitaly/v14/client"
+	"gitlab.com/gitlab-org/gitaly/v14/proto/go/gitalypb"
+)
+
+// procReceive is the client half of the "proc-receive" subcommand: once
+// registered in main's subcommands map, it would be configured as Git's
+// proc-receive hook for a repository that has one or more
+// receive.procReceiveRefs patterns configured, and forward the push
+// negotiation to HookService.ProcReceiveHook over the Gitaly socket named by
+// GITALY_SOCKET, so that push-to-review flows (e.g. refs/for/*) can be
+// handled centrally instead of per-repository.
+//
+// It is not yet registered: ProcReceiveHook has no server-side
+// implementation in this tree (see main.go), so every call here would fail
+// with codes.Unimplemented.
+func procReceive(args []string) int {
+	ctx := context.Background()
+
+	addr := os.Getenv("GITALY_SOCKET")
+	if addr == "" {
+		fmt.Fprintln(os.Stderr, "proc-receive: GITALY_SOCKET is not set")
+		return 1
+	}
+
+	repository, err := repositoryFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "proc-receive: %v\n", err)
+		return 1
+	}
+
+	conn, err := client.Dial(addr, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "proc-receive: dialing gitaly: %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+
+	stream, err := gitalypb.NewHookServiceClient(conn).ProcReceiveHook(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "proc-receive: %v\n", err)
+		return 1
+	}
+
+	if err := stream.Send(&gitalypb.ProcReceiveHookRequest{
+		Repository:           repository,
+		EnvironmentVariables: os.Environ(),
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "proc-receive: sending preamble: %v\n", err)
+		return 1
+	}
+
+	errC := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				if sendErr := stream.Send(&gitalypb.ProcReceiveHookRequest{Stdin: buf[:n]}); sendErr != nil {
+					errC <- sendErr
+					return
+				}
+			}
+			if err == io.EOF {
+				errC <- stream.CloseSend()
+				return
+			}
+			if err != nil {
+				errC <- err
+				return
+			}
+		}
+	}()
+
+	stdout := bufio.NewWriter(os.Stdout)
+	defer stdout.Flush()
+
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "proc-receive: %v\n", err)
+			return 1
+		}
+
+		if _, err := stdout.Write(response.GetStdout()); err != nil {
+			fmt.Fprintf(os.Stderr, "proc-receive: writing stdout: %v\n", err)
+			return 1
+		}
+		if _, err := os.Stderr.Write(response.GetStderr()); err != nil {
+			return 1
+		}
+
+		if status := response.GetExitStatus(); status != nil {
+			// Don't block on the stdin-forwarding goroutine here: in a
+			// real proc-receive invocation, receive-pack keeps our
+			// stdin open for as long as the hook process runs, so it
+			// only reaches EOF (and writes to errC) after we exit -
+			// never before. Only surface an error if the goroutine
+			// already finished on its own.
+			select {
+			case err := <-errC:
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "proc-receive: %v\n", err)
+				}
+			default:
+			}
+			return int(status.GetValue())
+		}
+	}
+
+	return 0
+}
+
+func repositoryFromEnv() (*gitalypb.Repository, error) {
+	storage := os.Getenv("GITALY_REPO_STORAGE")
+	relativePath := os.Getenv("GITALY_REPO_PATH")
+	if storage == "" || relativePath == "" {
+		return nil, fmt.Errorf("GITALY_REPO_STORAGE and GITALY_REPO_PATH must be set")
+	}
+
+	return &gitalypb.Repository{
+		StorageName:  storage,
+		RelativePath: relativePath,
+	}, nil
+}
@@ -0,0 +1,125 @@
+// Package cache tracks Gitaly's response cache entries and their
+// invalidation. Entry keys are recorded per repository, tagged with the refs
+// they were computed from, so that a ref update only has to drop the cache
+// entries that actually depended on it. The index is held in memory; the
+// response bodies themselves are not stored by this package.
+package cache
+
+import (
+	"sync"
+
+	"gitlab.com/gitlab-org/gitaly/v14/internal/gitaly/config"
+	"gitlab.com/gitlab-org/gitaly/v14/proto/go/gitalypb"
+)
+
+// entry is one cached response, tagged with the refs it was computed from.
+// A nil refs means the entry depends on the repository as a whole and must
+// be dropped on any invalidation.
+type entry struct {
+	refs map[string]struct{}
+}
+
+// DiskCache is an in-memory index of Gitaly's cached responses. It tracks,
+// per repository, which refs each cached entry depends on, so that
+// InvalidateRefs can drop only the entries a given set of ref updates
+// actually touched.
+type DiskCache struct {
+	cfg     config.Cfg
+	locator config.Locator
+
+	mu      sync.Mutex
+	entries map[string]map[string]*entry // repoKey -> cache key -> entry
+}
+
+// New builds a DiskCache for the given configuration and locator.
+func New(cfg config.Cfg, locator config.Locator) *DiskCache {
+	return &DiskCache{
+		cfg:     cfg,
+		locator: locator,
+		entries: make(map[string]map[string]*entry),
+	}
+}
+
+// repoKey uniquely identifies a repository within the cache.
+func repoKey(repo *gitalypb.Repository) string {
+	return repo.GetStorageName() + ":" + repo.GetRelativePath()
+}
+
+// Put records that the response stored under key for repo depends on refs.
+// A nil or empty refs means the entry depends on the whole repository and
+// will be invalidated by any ref update.
+func (c *DiskCache) Put(repo *gitalypb.Repository, key string, refs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	repoEntries, ok := c.entries[repoKey(repo)]
+	if !ok {
+		repoEntries = make(map[string]*entry)
+		c.entries[repoKey(repo)] = repoEntries
+	}
+
+	e := &entry{}
+	if len(refs) > 0 {
+		e.refs = make(map[string]struct{}, len(refs))
+		for _, ref := range refs {
+			e.refs[ref] = struct{}{}
+		}
+	}
+
+	repoEntries[key] = e
+}
+
+// Has reports whether key is still cached for repo. It exists mainly so
+// tests can observe the effect of invalidation without reaching into the
+// cache's internals.
+func (c *DiskCache) Has(repo *gitalypb.Repository, key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.entries[repoKey(repo)][key]
+	return ok
+}
+
+// InvalidateRepo drops every cached entry for repo, regardless of which
+// refs they depended on. This is the original, coarse-grained invalidation
+// performed on every mutator RPC.
+func (c *DiskCache) InvalidateRepo(repo *gitalypb.Repository) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, repoKey(repo))
+	return nil
+}
+
+// InvalidateRefs drops only the cached entries for repo that depend on one
+// of refs (or that depend on the whole repository), leaving entries scoped
+// to unrelated refs intact.
+func (c *DiskCache) InvalidateRefs(repo *gitalypb.Repository, refs []string) error {
+	if len(refs) == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	repoEntries, ok := c.entries[repoKey(repo)]
+	if !ok {
+		return nil
+	}
+
+	for key, e := range repoEntries {
+		if e.refs == nil {
+			delete(repoEntries, key)
+			continue
+		}
+
+		for _, ref := range refs {
+			if _, touched := e.refs[ref]; touched {
+				delete(repoEntries, key)
+				break
+			}
+		}
+	}
+
+	return nil
+}
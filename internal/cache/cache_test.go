@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/gitaly/config"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/testhelper"
+	"gitlab.com/gitlab-org/gitaly/v14/proto/go/gitalypb"
+)
+
+func TestDiskCache_InvalidateRefs(t *testing.T) {
+	repo := &gitalypb.Repository{StorageName: "default", RelativePath: "repo.git"}
+
+	c := New(config.Cfg{}, nil)
+	c.Put(repo, "branch-main", []string{"refs/heads/main"})
+	c.Put(repo, "branch-feature", []string{"refs/heads/feature"})
+	c.Put(repo, "repo-wide", nil)
+
+	require.NoError(t, c.InvalidateRefs(repo, []string{"refs/heads/main"}))
+
+	require.False(t, c.Has(repo, "branch-main"), "entry scoped to the updated ref should be dropped")
+	require.True(t, c.Has(repo, "branch-feature"), "entry scoped to an unrelated ref should survive")
+	require.False(t, c.Has(repo, "repo-wide"), "repository-wide entry should always be dropped")
+}
+
+func TestDiskCache_InvalidateRefs_otherRepoUnaffected(t *testing.T) {
+	repoA := &gitalypb.Repository{StorageName: "default", RelativePath: "a.git"}
+	repoB := &gitalypb.Repository{StorageName: "default", RelativePath: "b.git"}
+
+	c := New(config.Cfg{}, nil)
+	c.Put(repoA, "branch-main", []string{"refs/heads/main"})
+	c.Put(repoB, "branch-main", []string{"refs/heads/main"})
+
+	require.NoError(t, c.InvalidateRefs(repoA, []string{"refs/heads/main"}))
+
+	require.False(t, c.Has(repoA, "branch-main"))
+	require.True(t, c.Has(repoB, "branch-main"), "invalidation in one repository must not affect another")
+}
+
+func TestReferenceTransactionNotifier(t *testing.T) {
+	ctx := testhelper.Context(t)
+	repo := &gitalypb.Repository{StorageName: "default", RelativePath: "repo.git"}
+
+	c := New(config.Cfg{}, nil)
+	c.Put(repo, "branch-main", []string{"refs/heads/main"})
+	c.Put(repo, "branch-feature", []string{"refs/heads/feature"})
+
+	notifier := NewReferenceTransactionNotifier(c)
+	notifier.Prepare(repo, []ReferenceUpdate{{Ref: "refs/heads/main", OldOID: "a", NewOID: "b"}})
+
+	require.NoError(t, notifier.Notify(ctx, repo, ReferenceTransactionPrepared))
+	require.True(t, c.Has(repo, "branch-main"), "preparing a transaction must not invalidate anything yet")
+
+	require.NoError(t, notifier.Notify(ctx, repo, ReferenceTransactionCommitted))
+	require.False(t, c.Has(repo, "branch-main"), "committing the transaction invalidates the refs it touched")
+	require.True(t, c.Has(repo, "branch-feature"), "an unrelated ref's cache entry must survive")
+}
+
+func TestReferenceTransactionNotifier_abortedDoesNotInvalidate(t *testing.T) {
+	ctx := testhelper.Context(t)
+	repo := &gitalypb.Repository{StorageName: "default", RelativePath: "repo.git"}
+
+	c := New(config.Cfg{}, nil)
+	c.Put(repo, "branch-main", []string{"refs/heads/main"})
+
+	notifier := NewReferenceTransactionNotifier(c)
+	notifier.Prepare(repo, []ReferenceUpdate{{Ref: "refs/heads/main", OldOID: "a", NewOID: "b"}})
+
+	require.NoError(t, notifier.Notify(ctx, repo, ReferenceTransactionPrepared))
+	require.NoError(t, notifier.Notify(ctx, repo, ReferenceTransactionAborted))
+
+	require.True(t, c.Has(repo, "branch-main"), "an aborted transaction must not invalidate anything")
+}
+
+func TestPostReceiveNotifier(t *testing.T) {
+	ctx := testhelper.Context(t)
+	repo := &gitalypb.Repository{StorageName: "default", RelativePath: "repo.git"}
+
+	c := New(config.Cfg{}, nil)
+	c.Put(repo, "branch-main", []string{"refs/heads/main"})
+	c.Put(repo, "branch-feature", []string{"refs/heads/feature"})
+
+	notifier := NewPostReceiveNotifier(c)
+	require.NoError(t, notifier.Notify(ctx, repo, []string{"refs/heads/main"}))
+
+	require.False(t, c.Has(repo, "branch-main"))
+	require.True(t, c.Has(repo, "branch-feature"))
+}
+
+// TestReferenceTransactionNotifier_concurrentRepos exercises Prepare and
+// Notify for many repositories concurrently under -race: separate
+// reference-transaction hook streams for different repositories run
+// concurrently with no synchronization between them beyond what the
+// notifier itself provides, so pending must be safe for concurrent access
+// on its own.
+func TestReferenceTransactionNotifier_concurrentRepos(t *testing.T) {
+	ctx := testhelper.Context(t)
+	c := New(config.Cfg{}, nil)
+	notifier := NewReferenceTransactionNotifier(c)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		repo := &gitalypb.Repository{StorageName: "default", RelativePath: fmt.Sprintf("repo-%d.git", i)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			notifier.Prepare(repo, []ReferenceUpdate{{Ref: "refs/heads/main"}})
+			require.NoError(t, notifier.Notify(ctx, repo, ReferenceTransactionCommitted))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPostReceiveNotifier_noUpdatedRefsIsNoop(t *testing.T) {
+	ctx := testhelper.Context(t)
+	repo := &gitalypb.Repository{StorageName: "default", RelativePath: "repo.git"}
+
+	c := New(config.Cfg{}, nil)
+	c.Put(repo, "branch-main", []string{"refs/heads/main"})
+
+	notifier := NewPostReceiveNotifier(c)
+	require.NoError(t, notifier.Notify(ctx, repo, nil))
+
+	require.True(t, c.Has(repo, "branch-main"))
+}
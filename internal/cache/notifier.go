@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"gitlab.com/gitlab-org/gitaly/v14/proto/go/gitalypb"
+)
+
+// ReferenceTransactionPhase mirrors the phase field of a
+// ReferenceTransactionHook invocation: Git calls the hook once while the
+// transaction is prepared, and again once it has either been committed or
+// aborted.
+//
+// This type is deliberately independent of gitalypb.ReferenceTransactionHookRequest,
+// which has no definition in this repository: there is no hook-service gRPC
+// server here to decode a real request into it, but the invalidation logic
+// itself does not need one to be tested.
+type ReferenceTransactionPhase int
+
+const (
+	// ReferenceTransactionPrepared is reported before any ref is updated.
+	ReferenceTransactionPrepared ReferenceTransactionPhase = iota
+	// ReferenceTransactionCommitted is reported once the updates in a
+	// prepared transaction have taken effect.
+	ReferenceTransactionCommitted
+	// ReferenceTransactionAborted is reported if a prepared transaction
+	// did not go on to commit.
+	ReferenceTransactionAborted
+)
+
+// ReferenceUpdate is a single ref changed by a transaction.
+type ReferenceUpdate struct {
+	Ref            string
+	OldOID, NewOID string
+}
+
+// ReferenceTransactionNotifier invalidates cache entries in response to
+// ReferenceTransactionHook invocations. It only invalidates on a Committed
+// phase that was preceded by a matching Prepared phase for the same
+// repository, since an aborted transaction never took effect and should
+// leave the cache untouched.
+//
+// Nothing in this repository currently calls Prepare or Notify: there is no
+// hook-service gRPC server here (see ReferenceTransactionPhase) to receive a
+// ReferenceTransactionHook stream and drive this notifier from it. Wiring
+// this up is an open follow-up, not something this type or its tests take
+// care of on their own.
+type ReferenceTransactionNotifier struct {
+	cache *DiskCache
+
+	// mu guards pending: separate reference-transaction hook streams for
+	// different repositories run concurrently, and each one calls Prepare
+	// and Notify without any other synchronization between them.
+	mu sync.Mutex
+	// pending tracks, per repository, the refs of a transaction that has
+	// been prepared but not yet resolved.
+	pending map[string][]ReferenceUpdate
+}
+
+// NewReferenceTransactionNotifier returns a notifier that invalidates
+// entries in cache.
+func NewReferenceTransactionNotifier(cache *DiskCache) *ReferenceTransactionNotifier {
+	return &ReferenceTransactionNotifier{
+		cache:   cache,
+		pending: make(map[string][]ReferenceUpdate),
+	}
+}
+
+// Prepare records updates as the pending transaction for repo.
+func (n *ReferenceTransactionNotifier) Prepare(repo *gitalypb.Repository, updates []ReferenceUpdate) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pending[repoKey(repo)] = updates
+}
+
+// Notify resolves the pending transaction for repo according to phase. On
+// ReferenceTransactionCommitted, the refs recorded by the preceding Prepare
+// call are invalidated. On ReferenceTransactionAborted, they are discarded
+// without invalidating anything. Notify returns an error if phase is
+// Committed but no matching Prepare call was observed.
+func (n *ReferenceTransactionNotifier) Notify(ctx context.Context, repo *gitalypb.Repository, phase ReferenceTransactionPhase) error {
+	key := repoKey(repo)
+
+	switch phase {
+	case ReferenceTransactionPrepared:
+		return nil
+	case ReferenceTransactionCommitted:
+		n.mu.Lock()
+		updates, ok := n.pending[key]
+		if ok {
+			delete(n.pending, key)
+		}
+		n.mu.Unlock()
+
+		if !ok {
+			return fmt.Errorf("cache: committed reference transaction without a prepared phase")
+		}
+
+		refs := make([]string, len(updates))
+		for i, u := range updates {
+			refs[i] = u.Ref
+		}
+		return n.cache.InvalidateRefs(repo, refs)
+	case ReferenceTransactionAborted:
+		n.mu.Lock()
+		delete(n.pending, key)
+		n.mu.Unlock()
+		return nil
+	default:
+		return fmt.Errorf("cache: unknown reference transaction phase %v", phase)
+	}
+}
+
+// PostReceiveNotifier invalidates cache entries in response to
+// PostReceiveHook invocations, which report the refs a push updated without
+// the two-phase prepare/commit structure of ReferenceTransactionHook. Like
+// ReferenceTransactionNotifier, it is not yet wired into a real hook-service
+// handler; see that type's doc comment.
+type PostReceiveNotifier struct {
+	cache *DiskCache
+}
+
+// NewPostReceiveNotifier returns a notifier that invalidates entries in
+// cache.
+func NewPostReceiveNotifier(cache *DiskCache) *PostReceiveNotifier {
+	return &PostReceiveNotifier{cache: cache}
+}
+
+// Notify invalidates the cache entries scoped to updatedRefs. It is a no-op
+// if updatedRefs is empty, matching the hook's behavior when a push did not
+// actually change any refs (e.g. it only ran a hook-enabled pre-check).
+func (n *PostReceiveNotifier) Notify(ctx context.Context, repo *gitalypb.Repository, updatedRefs []string) error {
+	if len(updatedRefs) == 0 {
+		return nil
+	}
+	return n.cache.InvalidateRefs(repo, updatedRefs)
+}
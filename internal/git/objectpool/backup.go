@@ -0,0 +1,194 @@
+package objectpool
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitaly/v14/internal/command"
+)
+
+// manifestName is the name the backup manifest is written under in the tar
+// stream. WriteBackup always writes it as the first entry, and
+// RestoreBackup relies on that ordering to read it before it needs to make
+// sense of anything else in the stream.
+const manifestName = "manifest.json"
+
+// BackupManifest records the state of a pool at the time it was backed up,
+// so RestoreBackup has something to validate the restored files against
+// beyond "git fsck didn't complain".
+type BackupManifest struct {
+	// Head is the contents of the pool's HEAD file, with surrounding
+	// whitespace trimmed.
+	Head string `json:"head"`
+	// OriginRefs maps each ref under refs/remotes/origin to the object
+	// ID it pointed at when the backup was taken.
+	OriginRefs map[string]string `json:"origin_refs"`
+}
+
+// backupTarTime is substituted for every tar header's ModTime so that two
+// backups of the same pool state produce byte-identical streams: real
+// mtimes on the pool's files vary with when they happened to be written,
+// not with what they contain.
+var backupTarTime = time.Unix(0, 0)
+
+// WriteBackup writes a deterministic tar stream of poolPath's backed-up
+// state to w: its HEAD and packed-refs files, every pack/idx/bitmap file
+// and multi-pack-index under objects/pack, and a BackupManifest capturing
+// HEAD and a snapshot of refs/remotes/origin. It does not include loose
+// objects or refs/dangling/*, on the assumption that the pool has been
+// packed (see RepackStrategy) before it's backed up.
+func WriteBackup(ctx context.Context, w io.Writer, poolPath string) error {
+	manifest, err := buildManifest(ctx, poolPath)
+	if err != nil {
+		return fmt.Errorf("build manifest: %w", err)
+	}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+
+	files, err := backupFiles(poolPath)
+	if err != nil {
+		return fmt.Errorf("enumerate backup files: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+
+	if err := writeTarEntry(tw, manifestName, manifestJSON); err != nil {
+		return fmt.Errorf("write manifest entry: %w", err)
+	}
+
+	for _, relPath := range files {
+		contents, err := os.ReadFile(filepath.Join(poolPath, relPath))
+		if err != nil {
+			return fmt.Errorf("read %s: %w", relPath, err)
+		}
+
+		if err := writeTarEntry(tw, relPath, contents); err != nil {
+			return fmt.Errorf("write %s: %w", relPath, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+func writeTarEntry(tw *tar.Writer, name string, contents []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Size:    int64(len(contents)),
+		Mode:    0o644,
+		ModTime: backupTarTime,
+	}); err != nil {
+		return err
+	}
+
+	_, err := tw.Write(contents)
+	return err
+}
+
+// buildManifest reads poolPath's HEAD file and snapshots refs/remotes/origin
+// via `git for-each-ref`.
+func buildManifest(ctx context.Context, poolPath string) (BackupManifest, error) {
+	head, err := os.ReadFile(filepath.Join(poolPath, "HEAD"))
+	if err != nil {
+		return BackupManifest{}, err
+	}
+
+	originRefs, err := originRefs(ctx, poolPath)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+
+	return BackupManifest{
+		Head:       strings.TrimSpace(string(head)),
+		OriginRefs: originRefs,
+	}, nil
+}
+
+// originRefs snapshots refs/remotes/origin under gitDir via
+// `git for-each-ref`, mapping each ref to the object ID it currently points
+// at. It is used both to build a new backup's manifest and, against a
+// restored pool, to check one against it.
+func originRefs(ctx context.Context, gitDir string) (map[string]string, error) {
+	cmd, err := command.New(ctx, exec.Command(
+		"git", "--git-dir", gitDir, "for-each-ref",
+		"--format=%(refname) %(objectname)", sourceRefNamespace,
+	), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]string)
+	scanner := bufio.NewScanner(cmd)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		refs[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+// backupFiles returns, sorted, the paths (relative to poolPath) of every
+// file WriteBackup includes: HEAD and packed-refs at the top level, and
+// every pack/idx/bitmap file and multi-pack-index under objects/pack.
+func backupFiles(poolPath string) ([]string, error) {
+	files := []string{"HEAD"}
+
+	if _, err := os.Stat(filepath.Join(poolPath, "packed-refs")); err == nil {
+		files = append(files, "packed-refs")
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	packDir := filepath.Join(poolPath, "objects", "pack")
+	entries, err := os.ReadDir(packDir)
+	if os.IsNotExist(err) {
+		sort.Strings(files)
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isBackedUpPackFile(entry.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join("objects", "pack", entry.Name()))
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// isBackedUpPackFile reports whether name is one of the files a backup
+// needs to reconstruct a pool's packed state: a pack and its index, the
+// bitmap that may sit alongside either a single pack or a multi-pack index,
+// and the multi-pack index itself (see MidxRepackStrategy).
+func isBackedUpPackFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".pack", ".idx", ".bitmap":
+		return true
+	}
+	return name == "multi-pack-index"
+}
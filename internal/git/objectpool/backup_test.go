@@ -0,0 +1,144 @@
+package objectpool
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBackup_isDeterministic(t *testing.T) {
+	requireGit(t)
+	t.Parallel()
+
+	poolPath := newBackedUpPool(t)
+
+	var first, second bytes.Buffer
+	require.NoError(t, WriteBackup(context.Background(), &first, poolPath))
+	require.NoError(t, WriteBackup(context.Background(), &second, poolPath))
+
+	require.Equal(t, first.Bytes(), second.Bytes())
+}
+
+// TestBackupRoundTrip backs up a pool, deletes it, restores it from the
+// backup stream, and confirms a pool member whose objects/info/alternates
+// points at the restored pool can still see the pool's objects without any
+// further fetch: what an ObjectPool member actually depends on its pool
+// for.
+func TestBackupRoundTrip(t *testing.T) {
+	requireGit(t)
+	t.Parallel()
+
+	root := t.TempDir()
+	poolPath := filepath.Join(root, "pool.git")
+	require.NoError(t, os.Rename(newBackedUpPool(t), poolPath))
+
+	memberPath := filepath.Join(root, "member.git")
+	runGit(t, root, "clone", "--quiet", "--bare", "--shared", poolPath, memberPath)
+
+	var backup bytes.Buffer
+	require.NoError(t, WriteBackup(context.Background(), &backup, poolPath))
+
+	require.NoError(t, os.RemoveAll(poolPath))
+
+	require.NoError(t, RestoreBackup(context.Background(), bytes.NewReader(backup.Bytes()), poolPath))
+
+	require.NoError(t, os.WriteFile(
+		filepath.Join(memberPath, "objects", "info", "alternates"),
+		[]byte(filepath.Join(poolPath, "objects")+"\n"),
+		0o644,
+	))
+
+	runGit(t, memberPath, "fsck", "--connectivity-only")
+
+	commitCount := runGitOutput(t, memberPath, "rev-list", "--count", "HEAD")
+	require.Equal(t, "3", commitCount)
+}
+
+func TestRestoreBackup_rejectsPathTraversal(t *testing.T) {
+	t.Parallel()
+
+	destDir := t.TempDir()
+	_, err := safeJoin(destDir, "../escape")
+	require.Error(t, err)
+}
+
+func TestRestoreBackup_rejectsMismatchedHead(t *testing.T) {
+	requireGit(t)
+	t.Parallel()
+
+	poolPath := newBackedUpPool(t)
+
+	var backup bytes.Buffer
+	require.NoError(t, WriteBackup(context.Background(), &backup, poolPath))
+
+	// The manifest is the first entry, so this changes the HEAD it
+	// recorded. The replacement has the same length as the original so
+	// that the tar framing and the manifest's JSON stay intact.
+	tampered := bytes.Replace(backup.Bytes(), []byte("refs/heads/main"), []byte("refs/heads/next"), 1)
+
+	destPath := filepath.Join(t.TempDir(), "restored.git")
+	err := RestoreBackup(context.Background(), bytes.NewReader(tampered), destPath)
+	require.Error(t, err)
+}
+
+// TestRestoreBackup_rejectsMismatchedOriginRefs confirms that a backup whose
+// manifest and refs/remotes/origin contents disagree - here, by changing the
+// object ID the manifest (the stream's first entry) recorded for the ref to
+// a different commit than the one packed-refs points it at - fails
+// restoration instead of silently accepting a pool whose origin refs don't
+// match what was backed up.
+func TestRestoreBackup_rejectsMismatchedOriginRefs(t *testing.T) {
+	requireGit(t)
+	t.Parallel()
+
+	poolPath := newBackedUpPool(t)
+
+	otherOid := runGitOutput(t, poolPath, "rev-parse", "refs/remotes/origin/main~1")
+
+	var backup bytes.Buffer
+	require.NoError(t, WriteBackup(context.Background(), &backup, poolPath))
+
+	originOid := runGitOutput(t, poolPath, "rev-parse", "refs/remotes/origin/main")
+	tampered := bytes.Replace(backup.Bytes(), []byte(originOid), []byte(otherOid), 1)
+	require.NotEqual(t, backup.Bytes(), tampered, "fixture did not contain the origin ref's OID to tamper with")
+
+	destPath := filepath.Join(t.TempDir(), "restored.git")
+	err := RestoreBackup(context.Background(), bytes.NewReader(tampered), destPath)
+	require.Error(t, err)
+}
+
+// newBackedUpPool creates a bare repository with three commits, repacked
+// and bitmapped, with refs/remotes/origin/main tracking the same history
+// the way an ObjectPool's own fetch (see fetch.go) would leave it, suitable
+// as the pool side of a backup/restore round trip.
+func newBackedUpPool(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	poolPath := filepath.Join(root, "pool.git")
+	runGit(t, root, "init", "--quiet", "--bare", poolPath)
+	runGit(t, poolPath, "symbolic-ref", "HEAD", "refs/heads/main")
+
+	workPath := filepath.Join(root, "work")
+	runGit(t, root, "clone", "--quiet", poolPath, workPath)
+	runGit(t, workPath, "config", "user.email", "test@example.com")
+	runGit(t, workPath, "config", "user.name", "Test")
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(workPath, "file.txt"), []byte{byte('a' + i)}, 0o644))
+		runGit(t, workPath, "add", "file.txt")
+		runGit(t, workPath, "commit", "--quiet", "-m", "commit")
+	}
+	runGit(t, workPath, "push", "--quiet", "origin", "main")
+
+	runGit(t, poolPath, "update-ref", "refs/remotes/origin/main", "refs/heads/main")
+
+	runGit(t, poolPath, "repack", "-adb", "-q")
+	runGit(t, poolPath, "pack-refs", "--all")
+
+	return poolPath
+}
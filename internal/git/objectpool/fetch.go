@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
@@ -44,7 +45,7 @@ func (o *ObjectPool) FetchFromOrigin(ctx context.Context, origin *localrepo.Repo
 
 	refSpec := fmt.Sprintf("+refs/*:%s/*", sourceRefNamespace)
 	var stderr bytes.Buffer
-	if err := o.Repo.ExecAndWait(ctx,
+	fetchErr := o.Repo.ExecAndWait(ctx,
 		git.SubCmd{
 			Name: "fetch",
 			Flags: []git.Option{
@@ -63,12 +64,26 @@ func (o *ObjectPool) FetchFromOrigin(ctx context.Context, origin *localrepo.Repo
 		},
 		git.WithRefTxHook(o.Repo),
 		git.WithStderr(&stderr),
-	); err != nil {
-		return helper.ErrInternalf("fetch into object pool: %w, stderr: %q", err,
+		git.WithConfig(o.cfg.fsckConfigPairs()...),
+	)
+
+	// fsck issues are recorded regardless of whether the fetch ultimately
+	// failed, so that a StrictFsck rejection still leaves a trail of what
+	// was wrong with the origin repository.
+	recordFsckIssues(stderr.String())
+
+	if fetchErr != nil {
+		return helper.ErrInternalf("fetch into object pool: %w, stderr: %q", fetchErr,
 			stderr.String())
 	}
 
-	if err := o.rescueDanglingObjects(ctx); err != nil {
+	if !o.cfg.CruftRepack {
+		if err := o.rescueDanglingObjects(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := o.trackRepackThresholds(ctx); err != nil {
 		return err
 	}
 
@@ -96,6 +111,11 @@ const danglingObjectNamespace = "refs/dangling/"
 // relies on. There is currently no way for us to reliably determine if
 // an object is still used anywhere, so the only safe thing to do is to
 // assume that every object _is_ used.
+//
+// Callers skip this entirely when ObjectPoolConfig.CruftRepack is set,
+// since a cruft pack already keeps unreachable objects around for its
+// configured expiration without the ever-growing refs/dangling/*
+// namespace this scheme produces.
 func (o *ObjectPool) rescueDanglingObjects(ctx context.Context) error {
 	fsck, err := o.Repo.Exec(ctx, git.SubCmd{
 		Name:  "fsck",
@@ -139,29 +159,109 @@ func (o *ObjectPool) rescueDanglingObjects(ctx context.Context) error {
 }
 
 func (o *ObjectPool) repackPool(ctx context.Context, pool repository.GitRepo) error {
-	config := []git.ConfigPair{
-		{Key: "pack.island", Value: sourceRefNamespace + "/he(a)ds"},
-		{Key: "pack.island", Value: sourceRefNamespace + "/t(a)gs"},
-		{Key: "pack.islandCore", Value: "a"},
-		{Key: "pack.writeBitmapHashCache", Value: "true"},
+	strategy, err := o.selectRepackStrategy(ctx)
+	if err != nil {
+		return err
 	}
 
-	if err := o.Repo.ExecAndWait(ctx, git.SubCmd{
-		Name: "repack",
-		Flags: []git.Option{
-			git.Flag{Name: "-aidb"},
-			// This can be removed as soon as we have upstreamed a
-			// `repack.updateServerInfo` config option. See gitlab-org/git#105 for more
-			// details.
-			git.Flag{Name: "-n"},
-		},
-	}, git.WithConfig(config...)); err != nil {
+	if err := strategy.Repack(ctx, o, pool); err != nil {
+		return err
+	}
+
+	switch strategy.(type) {
+	case FullRepackStrategy, MidxRepackStrategy:
+		if err := o.resetRepackThresholdBaseline(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resetRepackThresholdBaseline records the pool's on-disk object size and
+// ref count right after a full (or midx) repack as the baseline
+// trackRepackThresholds measures growth from, and zeroes the accumulated
+// deltas selectRepackStrategy compares against FullRepackByteThreshold and
+// FullRepackRefThreshold.
+func (o *ObjectPool) resetRepackThresholdBaseline(ctx context.Context) error {
+	objectsSize, err := sizeDir(ctx, filepath.Join(o.FullPath(), "objects"))
+	if err != nil {
+		return err
+	}
+
+	refCount, err := o.countRefs(ctx)
+	if err != nil {
+		return err
+	}
+
+	o.bytesAtLastFullRepack = objectsSize
+	o.refsAtLastFullRepack = refCount
+	o.bytesSinceFullRepack = 0
+	o.refsSinceFullRepack = 0
+
+	return nil
+}
+
+// trackRepackThresholds updates the counters selectRepackStrategy compares
+// against FullRepackByteThreshold and FullRepackRefThreshold: how much the
+// pool's on-disk object size and ref count have grown since
+// bytesAtLastFullRepack/refsAtLastFullRepack, the baseline the last full (or
+// midx) repack left it at. Using the pool's absolute on-disk size and ref
+// count here instead, as trackRepackThresholds used to, would pin a pool
+// that has ever crossed the threshold above it forever, forcing a full
+// repack on every subsequent fetch instead of letting geometric repacks
+// handle the day-to-day growth as intended.
+func (o *ObjectPool) trackRepackThresholds(ctx context.Context) error {
+	objectsSize, err := sizeDir(ctx, filepath.Join(o.FullPath(), "objects"))
+	if err != nil {
+		return err
+	}
+	o.bytesSinceFullRepack = nonNegativeDelta(objectsSize, o.bytesAtLastFullRepack)
+
+	refCount, err := o.countRefs(ctx)
+	if err != nil {
 		return err
 	}
+	o.refsSinceFullRepack = nonNegativeDelta(refCount, o.refsAtLastFullRepack)
 
 	return nil
 }
 
+// countRefs returns the number of refs under refs/ in the pool.
+func (o *ObjectPool) countRefs(ctx context.Context) (int64, error) {
+	forEachRef, err := o.Repo.Exec(ctx, git.SubCmd{
+		Name: "for-each-ref",
+		Args: []string{"refs/"},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var refCount int64
+	scanner := bufio.NewScanner(forEachRef)
+	for scanner.Scan() {
+		refCount++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if err := forEachRef.Wait(); err != nil {
+		return 0, err
+	}
+
+	return refCount, nil
+}
+
+// nonNegativeDelta returns current-baseline, floored at 0 so that a pool
+// whose ref count or on-disk size has dropped below its last full-repack
+// baseline doesn't wrap around into a negative "amount grown since".
+func nonNegativeDelta(current, baseline int64) int64 {
+	if current < baseline {
+		return 0
+	}
+	return current - baseline
+}
+
 func (o *ObjectPool) logStats(ctx context.Context, when string) error {
 	fields := logrus.Fields{
 		"when": when,
@@ -219,11 +319,129 @@ func (o *ObjectPool) logStats(ctx context.Context, when string) error {
 		fields["normal."+key+".ref"] = normalRefsByType[key]
 	}
 
+	if o.cfg.CruftRepack {
+		cruftSize, cruftObjects, err := cruftPackStats(filepath.Join(o.FullPath(), "objects", "pack"))
+		if err != nil {
+			return err
+		}
+		fields["cruftPackSize"] = cruftSize
+		fields["cruftPackObjects"] = cruftObjects
+	}
+
+	if o.cfg.MidxBitmaps {
+		midxSize, packCount, err := midxStats(filepath.Join(o.FullPath(), "objects", "pack"))
+		if err != nil {
+			return err
+		}
+		fields["midxSize"] = midxSize
+		fields["midxPackCount"] = packCount
+	}
+
 	ctxlogrus.Extract(ctx).WithFields(fields).Info("pool dangling ref stats")
 
 	return nil
 }
 
+// cruftPackStats reports the combined size and object count of every cruft
+// pack under packDir. A pack is a cruft pack if and only if it has a
+// "<pack>.mtimes" file alongside its "<pack>.idx", which is how `git repack
+// --cruft` records the per-object mtimes it uses to expire unreachable
+// objects; a normal pack has no such sibling.
+func cruftPackStats(packDir string) (size int64, objects int, err error) {
+	entries, err := os.ReadDir(packDir)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".idx" {
+			continue
+		}
+
+		base := strings.TrimSuffix(entry.Name(), ".idx")
+		if _, err := os.Stat(filepath.Join(packDir, base+".mtimes")); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return 0, 0, err
+		}
+
+		packInfo, err := os.Stat(filepath.Join(packDir, base+".pack"))
+		if err != nil {
+			return 0, 0, err
+		}
+		size += packInfo.Size()
+
+		count, err := countPackObjects(filepath.Join(packDir, entry.Name()))
+		if err != nil {
+			return 0, 0, err
+		}
+		objects += count
+	}
+
+	return size, objects, nil
+}
+
+// midxStats reports the on-disk size of the multi-pack index under packDir
+// and the number of packs it covers. git writes the index itself to a file
+// literally named "multi-pack-index" (its sibling bitmap, if any, is
+// "multi-pack-index-<hash>.bitmap"), so its size alone isn't informative
+// without also knowing how many packs it was built over.
+func midxStats(packDir string) (size int64, packCount int, err error) {
+	info, err := os.Stat(filepath.Join(packDir, "multi-pack-index"))
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	entries, err := os.ReadDir(packDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".pack" {
+			packCount++
+		}
+	}
+
+	return info.Size(), packCount, nil
+}
+
+// countPackObjects returns the number of objects in the pack indexed by
+// idxPath, one per line of `git show-index`'s output.
+func countPackObjects(idxPath string) (int, error) {
+	idx, err := os.Open(idxPath)
+	if err != nil {
+		return 0, err
+	}
+	defer idx.Close()
+
+	cmd := exec.Command("git", "show-index")
+	cmd.Stdin = idx
+
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			count++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
 func sizeDir(ctx context.Context, dir string) (int64, error) {
 	// du -k reports size in KB
 	cmd, err := command.New(ctx, exec.Command("du", "-sk", dir), nil, nil, nil)
@@ -0,0 +1,117 @@
+package objectpool
+
+import (
+	"regexp"
+	"time"
+
+	"gitlab.com/gitlab-org/gitaly/v14/internal/git"
+)
+
+// FsckSeverity controls how `git fsck` (and fetch/transfer's built-in fsck
+// checks) treat a single class of object corruption, identified by its
+// message ID (e.g. "zeroPaddedFilemode").
+type FsckSeverity string
+
+const (
+	// FsckSeverityError aborts the operation that triggered the check.
+	FsckSeverityError FsckSeverity = "error"
+	// FsckSeverityWarn logs the issue but lets the operation proceed.
+	FsckSeverityWarn FsckSeverity = "warn"
+	// FsckSeverityIgnore suppresses the check entirely.
+	FsckSeverityIgnore FsckSeverity = "ignore"
+)
+
+// ObjectPoolConfig holds the rarely-changed, operator-facing settings of an
+// ObjectPool.
+type ObjectPoolConfig struct {
+	// StrictFsck rejects a fetch into the pool if it would pull in an
+	// object with an fsck issue that isn't otherwise downgraded to warn
+	// or ignore by FsckMessageSeverities; defaultLenientFsckSeverities
+	// are not applied. When false, defaultLenientFsckSeverities are
+	// downgraded to warn, so such a fetch proceeds and the issue is only
+	// logged and counted.
+	StrictFsck bool
+	// FsckMessageSeverities overrides the severity of individual fsck
+	// message IDs, regardless of StrictFsck.
+	FsckMessageSeverities map[string]FsckSeverity
+
+	// CruftRepack moves unreachable objects into a cruft pack with
+	// mtime-based expiration instead of keeping them reachable forever
+	// via refs/dangling/*. When set, it takes priority over the
+	// full/geometric threshold fields below.
+	CruftRepack bool
+	// CruftExpiration is how long an unreachable object is kept in the
+	// cruft pack before a future repack may discard it.
+	CruftExpiration time.Duration
+
+	// FullRepackByteThreshold triggers a full repack once at least this
+	// many bytes have been fetched into the pool since the last one. Zero
+	// disables this trigger.
+	FullRepackByteThreshold int64
+	// FullRepackRefThreshold triggers a full repack once at least this
+	// many refs have been fetched into the pool since the last one. Zero
+	// disables this trigger.
+	FullRepackRefThreshold int64
+	// GeometricRepackFactor is the factor each pack in the geometric
+	// sequence should be smaller than its predecessor by. Values below 2
+	// are treated as 2, matching `git repack --geometric`'s own floor.
+	GeometricRepackFactor int
+
+	// MidxBitmaps enables writing a multi-pack index with a bitmap on
+	// every full repack, instead of the single-pack bitmap
+	// pack.writeBitmapHashCache alone produces. This keeps bitmap
+	// lookups against the pool close to O(1) once it holds enough refs
+	// that a single-pack bitmap becomes a bottleneck to build and query.
+	MidxBitmaps bool
+}
+
+// defaultLenientFsckSeverities are downgraded to warn whenever StrictFsck is
+// false. These are checks that are common enough in real-world repository
+// history that rejecting them outright would make the object pool
+// unusable, but that we still want surfaced via metrics.
+var defaultLenientFsckSeverities = map[string]FsckSeverity{
+	"zeroPaddedFilemode":     FsckSeverityWarn,
+	"missingSpaceBeforeDate": FsckSeverityWarn,
+}
+
+// fsckConfigPairs builds the `-c` arguments that enable fsck checking on a
+// fetch/transfer and apply cfg's severity overrides.
+func (cfg ObjectPoolConfig) fsckConfigPairs() []git.ConfigPair {
+	pairs := []git.ConfigPair{
+		{Key: "fetch.fsckObjects", Value: "true"},
+		{Key: "transfer.fsckObjects", Value: "true"},
+	}
+
+	severities := make(map[string]FsckSeverity, len(defaultLenientFsckSeverities))
+	if !cfg.StrictFsck {
+		for msgID, severity := range defaultLenientFsckSeverities {
+			severities[msgID] = severity
+		}
+	}
+	for msgID, severity := range cfg.FsckMessageSeverities {
+		severities[msgID] = severity
+	}
+
+	for msgID, severity := range severities {
+		// fetch-pack reads its fsck severities under "fetch.fsck.*", not
+		// the "fsck.*" namespace that the standalone `git fsck` command
+		// uses.
+		pairs = append(pairs, git.ConfigPair{Key: "fetch.fsck." + msgID, Value: string(severity)})
+	}
+
+	return pairs
+}
+
+// fsckIssueRegexp matches the lines `git fsck`, and fetch/transfer's
+// built-in equivalent, print to stderr for each object that fails a check:
+// "error: object <oid>: <msg-id>: <description>" or the "warning:"
+// equivalent for non-fatal severities.
+var fsckIssueRegexp = regexp.MustCompile(`(?m)^(error|warning): object [0-9a-f]{4,}: ([A-Za-z0-9]+): `)
+
+// recordFsckIssues increments fetchFsckIssuesTotal for every fsck issue
+// found in stderr, labelled by its reported severity and message ID.
+func recordFsckIssues(stderr string) {
+	for _, match := range fsckIssueRegexp.FindAllStringSubmatch(stderr, -1) {
+		fetchFsckIssuesTotal.WithLabelValues(match[1], match[2]).Inc()
+	}
+}
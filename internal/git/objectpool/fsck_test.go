@@ -0,0 +1,201 @@
+package objectpool
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/git"
+)
+
+func gitConfigPair(key, value string) git.ConfigPair {
+	return git.ConfigPair{Key: key, Value: value}
+}
+
+func TestObjectPoolConfig_fsckConfigPairs(t *testing.T) {
+	t.Parallel()
+
+	t.Run("lenient downgrades the default severities", func(t *testing.T) {
+		t.Parallel()
+
+		pairs := ObjectPoolConfig{}.fsckConfigPairs()
+		require.Contains(t, pairs, gitConfigPair("fetch.fsckObjects", "true"))
+		require.Contains(t, pairs, gitConfigPair("transfer.fsckObjects", "true"))
+		require.Contains(t, pairs, gitConfigPair("fetch.fsck.zeroPaddedFilemode", "warn"))
+		require.Contains(t, pairs, gitConfigPair("fetch.fsck.missingSpaceBeforeDate", "warn"))
+	})
+
+	t.Run("strict leaves default severities alone", func(t *testing.T) {
+		t.Parallel()
+
+		pairs := ObjectPoolConfig{StrictFsck: true}.fsckConfigPairs()
+		require.NotContains(t, pairs, gitConfigPair("fetch.fsck.zeroPaddedFilemode", "warn"))
+	})
+
+	t.Run("explicit overrides apply regardless of StrictFsck", func(t *testing.T) {
+		t.Parallel()
+
+		pairs := ObjectPoolConfig{
+			StrictFsck:            true,
+			FsckMessageSeverities: map[string]FsckSeverity{"missingEmail": FsckSeverityIgnore},
+		}.fsckConfigPairs()
+		require.Contains(t, pairs, gitConfigPair("fetch.fsck.missingEmail", "ignore"))
+	})
+}
+
+func TestRecordFsckIssues(t *testing.T) {
+	t.Parallel()
+
+	fetchFsckIssuesTotal.Reset()
+
+	stderr := "warning: object abc123ff: zeroPaddedFilemode: contains zero-padded file modes\n" +
+		"error: object deadbeef: missingEmail: invalid author/committer line - missing email\n" +
+		"fatal: fsck error in packed object\n"
+
+	recordFsckIssues(stderr)
+
+	require.Equal(t, float64(1), testCounterValue(t, "warning", "zeroPaddedFilemode"))
+	require.Equal(t, float64(1), testCounterValue(t, "error", "missingEmail"))
+}
+
+func testCounterValue(t *testing.T, severity, msgID string) float64 {
+	t.Helper()
+
+	var metric dto.Metric
+	require.NoError(t, fetchFsckIssuesTotal.WithLabelValues(severity, msgID).Write(&metric))
+	return metric.GetCounter().GetValue()
+}
+
+// TestFetchFsck_zeroPaddedFilemodeEndToEnd seeds an origin repository with a
+// hand-crafted tree containing a zero-padded filemode entry, then fetches it
+// with the real `git` binary using exactly the flags fsckConfigPairs
+// produces, to pin down that this package's config actually controls git's
+// fsck behavior as intended: a fetch is rejected in strict mode, and
+// succeeds (while still reporting the issue) in lenient mode.
+//
+// This does not drive ObjectPool.FetchFromOrigin itself: ObjectPool's
+// constructor and its other dependencies (localrepo, the housekeeping
+// manager) are not available in this checkout, so the git invocation is
+// built directly from fsckConfigPairs instead.
+func TestFetchFsck_zeroPaddedFilemodeEndToEnd(t *testing.T) {
+	requireGit(t)
+	t.Parallel()
+
+	originPath := newCorruptOriginRepo(t)
+
+	t.Run("strict rejects the fetch", func(t *testing.T) {
+		t.Parallel()
+
+		poolPath := newBareRepo(t)
+		stderr, err := fetchInto(t, poolPath, originPath, ObjectPoolConfig{StrictFsck: true})
+		require.Error(t, err)
+		require.Contains(t, stderr, "zeroPaddedFilemode")
+
+		fetchFsckIssuesTotal.Reset()
+		recordFsckIssues(stderr)
+		require.Equal(t, float64(1), testCounterValue(t, "error", "zeroPaddedFilemode"))
+	})
+
+	t.Run("lenient accepts the fetch and still reports the issue", func(t *testing.T) {
+		t.Parallel()
+
+		poolPath := newBareRepo(t)
+		stderr, err := fetchInto(t, poolPath, originPath, ObjectPoolConfig{})
+		require.NoError(t, err)
+		require.Contains(t, stderr, "zeroPaddedFilemode")
+
+		fetchFsckIssuesTotal.Reset()
+		recordFsckIssues(stderr)
+		require.Equal(t, float64(1), testCounterValue(t, "warning", "zeroPaddedFilemode"))
+	})
+}
+
+func requireGit(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+}
+
+func newBareRepo(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	runGit(t, dir, "init", "--quiet", "--bare", ".")
+	return dir
+}
+
+// newCorruptOriginRepo builds a repository whose single commit's tree has a
+// zero-padded filemode ("0100644" instead of "100644") on one entry, which
+// `git fsck` reports as the zeroPaddedFilemode issue.
+func newCorruptOriginRepo(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	runGit(t, dir, "init", "--quiet", "-b", "main", ".")
+	runGit(t, dir, "config", "user.email", "test@example.com")
+	runGit(t, dir, "config", "user.name", "Test")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("hello\n"), 0o644))
+	blobOID := runGitOutput(t, dir, "hash-object", "-w", "file.txt")
+
+	blobRaw, err := hex.DecodeString(blobOID)
+	require.NoError(t, err)
+
+	treeEntry := append([]byte("0100644 file.txt\x00"), blobRaw...)
+	treeOID := runGitStdin(t, dir, treeEntry, "hash-object", "-w", "-t", "tree", "--stdin")
+
+	commitOID := runGitOutput(t, dir, "commit-tree", "-m", "corrupt", treeOID)
+	runGit(t, dir, "update-ref", "refs/heads/main", commitOID)
+
+	return dir
+}
+
+func fetchInto(t *testing.T, poolPath, originPath string, cfg ObjectPoolConfig) (string, error) {
+	t.Helper()
+
+	var args []string
+	for _, pair := range cfg.fsckConfigPairs() {
+		args = append(args, "-c", pair.Key+"="+pair.Value)
+	}
+	args = append(args, "fetch", "--quiet", "--no-tags", "--no-write-fetch-head",
+		originPath, "+refs/*:refs/remotes/origin/*")
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = poolPath
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, "git %v: %s", args, out)
+}
+
+func runGitOutput(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	require.NoError(t, err, "git %v", args)
+	return strings.TrimSpace(string(out))
+}
+
+func runGitStdin(t *testing.T, dir string, stdin []byte, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(stdin)
+	out, err := cmd.Output()
+	require.NoError(t, err, "git %v", args)
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,21 @@
+package objectpool
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// fetchFsckIssuesTotal counts fsck issues encountered while fetching objects
+// into an object pool from its origin, labelled by the severity Git reported
+// the issue at and the fsck message ID that triggered it. This lets
+// operators notice a corrupt upstream repository before it poisons every
+// pool member, even when ObjectPoolConfig.StrictFsck is false and the fetch
+// is allowed to proceed.
+var fetchFsckIssuesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gitaly_objectpool_fetch_fsck_issues_total",
+		Help: "Total number of fsck issues encountered fetching objects into an object pool",
+	},
+	[]string{"severity", "msg_id"},
+)
+
+func init() {
+	prometheus.MustRegister(fetchFsckIssuesTotal)
+}
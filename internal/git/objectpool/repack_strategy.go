@@ -0,0 +1,303 @@
+package objectpool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gitlab.com/gitlab-org/gitaly/v14/internal/git"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/git/repository"
+)
+
+// RepackStrategy packs a pool's objects, trading off how much work the
+// repack itself does against how much repacking work is deferred to a later
+// call.
+type RepackStrategy interface {
+	// Name identifies the strategy in logs and stats.
+	Name() string
+	// Repack runs this strategy's repack against pool.
+	Repack(ctx context.Context, repo *ObjectPool, pool repository.GitRepo) error
+}
+
+// repackIslandsConfig keeps commits reachable from heads and tags in
+// contiguous regions of the pack, which is worth preserving across every
+// repack strategy since it is what makes the eventual pack file delta
+// compression effective for clones and fetches.
+var repackIslandsConfig = []git.ConfigPair{
+	{Key: "pack.island", Value: sourceRefNamespace + "/he(a)ds"},
+	{Key: "pack.island", Value: sourceRefNamespace + "/t(a)gs"},
+	{Key: "pack.islandCore", Value: "a"},
+	{Key: "pack.writeBitmapHashCache", Value: "true"},
+}
+
+// FullRepackStrategy rewrites the entire pool into a single pack. It is the
+// most thorough strategy, and the most expensive: its cost is O(pool size)
+// regardless of how much has changed since the last repack.
+type FullRepackStrategy struct{}
+
+// Name implements RepackStrategy.
+func (FullRepackStrategy) Name() string { return "full" }
+
+// Repack implements RepackStrategy.
+func (FullRepackStrategy) Repack(ctx context.Context, o *ObjectPool, pool repository.GitRepo) error {
+	return o.Repo.ExecAndWait(ctx, git.SubCmd{
+		Name: "repack",
+		Flags: []git.Option{
+			git.Flag{Name: "-aidb"},
+			// This can be removed as soon as we have upstreamed a
+			// `repack.updateServerInfo` config option. See gitlab-org/git#105 for more
+			// details.
+			git.Flag{Name: "-n"},
+		},
+	}, git.WithConfig(repackIslandsConfig...))
+}
+
+// GeometricRepackStrategy repacks only as many of the most recently added
+// packs as it takes to restore the geometric size progression that Factor
+// describes, leaving older, larger packs untouched. This keeps the typical
+// cost of a repack proportional to how much was added since the last one,
+// rather than to the whole pool.
+type GeometricRepackStrategy struct {
+	Factor int
+}
+
+// Name implements RepackStrategy.
+func (GeometricRepackStrategy) Name() string { return "geometric" }
+
+// Repack implements RepackStrategy.
+func (s GeometricRepackStrategy) Repack(ctx context.Context, o *ObjectPool, pool repository.GitRepo) error {
+	factor := s.Factor
+	if factor < 2 {
+		factor = 2
+	}
+
+	return o.Repo.ExecAndWait(ctx, git.SubCmd{
+		Name: "repack",
+		Flags: []git.Option{
+			git.ValueFlag{Name: "--geometric", Value: fmt.Sprintf("%d", factor)},
+			git.Flag{Name: "-d"},
+			git.Flag{Name: "--write-bitmap-index"},
+		},
+	}, git.WithConfig(repackIslandsConfig...))
+}
+
+// CruftRepackStrategy moves unreachable objects into a separate cruft pack
+// instead of giving them refs/dangling/* references, letting Git expire
+// them by mtime instead of keeping them forever.
+type CruftRepackStrategy struct {
+	// Expiration is how long an unreachable object must have gone
+	// untouched before it is eligible for removal from the cruft pack by
+	// a future repack.
+	Expiration time.Duration
+}
+
+// Name implements RepackStrategy.
+func (CruftRepackStrategy) Name() string { return "cruft" }
+
+// Repack implements RepackStrategy.
+func (s CruftRepackStrategy) Repack(ctx context.Context, o *ObjectPool, pool repository.GitRepo) error {
+	expiration := s.Expiration
+	if expiration <= 0 {
+		expiration = 2 * 7 * 24 * time.Hour
+	}
+
+	return o.Repo.ExecAndWait(ctx, git.SubCmd{
+		Name: "repack",
+		Flags: []git.Option{
+			git.Flag{Name: "--cruft"},
+			git.ValueFlag{Name: "--cruft-expiration", Value: approxExpirationDate(expiration)},
+			git.Flag{Name: "-d"},
+		},
+	}, git.WithConfig(repackIslandsConfig...))
+}
+
+// MidxRepackStrategy rewrites the entire pool into a single pack, like
+// FullRepackStrategy, but additionally writes a multi-pack index with its
+// own bitmap over the result. A multi-pack bitmap's lookup table keeps
+// reachability queries close to O(1) as the pool's ref count grows, where a
+// single-pack bitmap (pack.writeBitmapHashCache alone) becomes increasingly
+// expensive to both build and query.
+type MidxRepackStrategy struct{}
+
+// Name implements RepackStrategy.
+func (MidxRepackStrategy) Name() string { return "midx" }
+
+// Repack implements RepackStrategy.
+func (MidxRepackStrategy) Repack(ctx context.Context, o *ObjectPool, pool repository.GitRepo) error {
+	if err := o.Repo.ExecAndWait(ctx, git.SubCmd{
+		Name: "repack",
+		Flags: []git.Option{
+			git.Flag{Name: "-adb"},
+		},
+	}, git.WithConfig(repackIslandsConfig...)); err != nil {
+		return err
+	}
+
+	packDir := filepath.Join(o.FullPath(), "objects", "pack")
+
+	writeFlags := []git.Option{git.Flag{Name: "--bitmap"}}
+	if preferredPack, ok, err := newestPackName(packDir); err != nil {
+		return err
+	} else if ok {
+		writeFlags = append(writeFlags, git.ValueFlag{Name: "--preferred-pack", Value: preferredPack})
+	}
+
+	if err := o.Repo.ExecAndWait(ctx, git.SubCmd{
+		Name:  "multi-pack-index",
+		Args:  []string{"write"},
+		Flags: writeFlags,
+	}, git.WithConfig(git.ConfigPair{Key: "pack.writeBitmapLookupTable", Value: "true"})); err != nil {
+		return err
+	}
+
+	return o.Repo.ExecAndWait(ctx, git.SubCmd{
+		Name: "multi-pack-index",
+		Args: []string{"verify"},
+	})
+}
+
+// newestPackName returns the filename (without directory) of the most
+// recently written pack under packDir, for use as multi-pack-index write's
+// --preferred-pack: the freshest pack is the one most likely to still be
+// warm in the kernel page cache, and ties object reuse during the bitmap
+// computation to it instead of an arbitrary older pack.
+func newestPackName(packDir string) (name string, ok bool, err error) {
+	entries, err := os.ReadDir(packDir)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	var newestName string
+	var newestModTime time.Time
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".pack" {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return "", false, err
+		}
+
+		if newestName == "" || info.ModTime().After(newestModTime) {
+			newestName = entry.Name()
+			newestModTime = info.ModTime()
+		}
+	}
+
+	return newestName, newestName != "", nil
+}
+
+// approxExpirationDate renders a duration as the "<N>.days.ago"-style
+// approxidate `git repack --cruft-expiration` expects.
+func approxExpirationDate(d time.Duration) string {
+	days := int(d.Round(24*time.Hour).Hours() / 24)
+	if days < 1 {
+		days = 1
+	}
+	return fmt.Sprintf("%d.days.ago", days)
+}
+
+// selectRepackStrategy picks the cheapest RepackStrategy that is still
+// appropriate for o's current state: cruft, if the pool enables it; full
+// (midx, if the pool enables multi-pack bitmaps), once enough has changed
+// since the last full repack to make a geometric repack's deferred cost not
+// worth it; geometric, if the newest pack's size divided by the next-newest
+// pack's size is below the geometric factor; full (or midx) otherwise,
+// including when there are fewer than two packs to compare. The byte/ref
+// threshold checks
+// below only pick full/midx correctly as long as
+// bytesSinceFullRepack/refsSinceFullRepack hold growth *since the last full
+// repack* rather than the pool's absolute size/ref count — see
+// trackRepackThresholds and resetRepackThresholdBaseline in fetch.go.
+func (o *ObjectPool) selectRepackStrategy(ctx context.Context) (RepackStrategy, error) {
+	if o.cfg.CruftRepack {
+		return CruftRepackStrategy{Expiration: o.cfg.CruftExpiration}, nil
+	}
+
+	if threshold := o.cfg.FullRepackByteThreshold; threshold > 0 && o.bytesSinceFullRepack >= threshold {
+		return o.fullRepackStrategy(), nil
+	}
+	if threshold := o.cfg.FullRepackRefThreshold; threshold > 0 && o.refsSinceFullRepack >= threshold {
+		return o.fullRepackStrategy(), nil
+	}
+
+	factor := o.cfg.GeometricRepackFactor
+	if factor < 2 {
+		factor = 2
+	}
+
+	ratio, ok, err := newestPackSizeRatio(filepath.Join(o.FullPath(), "objects", "pack"))
+	if err != nil {
+		return nil, err
+	}
+	if ok && ratio < float64(factor) {
+		return GeometricRepackStrategy{Factor: factor}, nil
+	}
+
+	return o.fullRepackStrategy(), nil
+}
+
+// fullRepackStrategy picks MidxRepackStrategy over plain FullRepackStrategy
+// when the pool has MidxBitmaps enabled, since both rewrite the whole pool
+// into a single pack and only differ in what bitmap they produce from it.
+func (o *ObjectPool) fullRepackStrategy() RepackStrategy {
+	if o.cfg.MidxBitmaps {
+		return MidxRepackStrategy{}
+	}
+	return FullRepackStrategy{}
+}
+
+// newestPackSizeRatio compares the two most recently written pack files
+// under packDir by size, returning size(newest)/size(next-newest).
+// selectRepackStrategy picks a geometric repack while this ratio is below
+// the geometric factor, that is while the newest pack has not grown to
+// Factor times the size of the one before it. ok is false if there are
+// fewer than two packs to compare.
+func newestPackSizeRatio(packDir string) (ratio float64, ok bool, err error) {
+	entries, err := os.ReadDir(packDir)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	type pack struct {
+		modTime time.Time
+		size    int64
+	}
+	var packs []pack
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".pack" {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return 0, false, err
+		}
+
+		packs = append(packs, pack{modTime: info.ModTime(), size: info.Size()})
+	}
+
+	if len(packs) < 2 {
+		return 0, false, nil
+	}
+
+	sort.Slice(packs, func(i, j int) bool { return packs[i].modTime.After(packs[j].modTime) })
+
+	newest, nextNewest := packs[0], packs[1]
+	if nextNewest.size == 0 {
+		return 0, false, nil
+	}
+
+	return float64(newest.size) / float64(nextNewest.size), true, nil
+}
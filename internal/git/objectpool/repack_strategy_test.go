@@ -0,0 +1,270 @@
+package objectpool
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApproxExpirationDate(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "14.days.ago", approxExpirationDate(14*24*time.Hour))
+	require.Equal(t, "1.days.ago", approxExpirationDate(0))
+	require.Equal(t, "1.days.ago", approxExpirationDate(-time.Hour))
+	require.Equal(t, "2.days.ago", approxExpirationDate(36*time.Hour))
+}
+
+func TestNewestPackSizeRatio(t *testing.T) {
+	t.Parallel()
+
+	t.Run("missing directory", func(t *testing.T) {
+		t.Parallel()
+
+		_, ok, err := newestPackSizeRatio(filepath.Join(t.TempDir(), "does-not-exist"))
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("fewer than two packs", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		writePack(t, dir, "pack-a.pack", 100, time.Now())
+
+		_, ok, err := newestPackSizeRatio(dir)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("ratio of newest to next-newest pack", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		now := time.Now()
+		writePack(t, dir, "pack-old.pack", 1000, now.Add(-time.Hour))
+		writePack(t, dir, "pack-new.pack", 100, now)
+
+		ratio, ok, err := newestPackSizeRatio(dir)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.InDelta(t, 0.1, ratio, 0.0001)
+	})
+
+	t.Run("non-pack files are ignored", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		now := time.Now()
+		writePack(t, dir, "pack-old.pack", 1000, now.Add(-time.Hour))
+		writePack(t, dir, "pack-new.pack", 500, now)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "pack-new.idx"), []byte("not a pack"), 0o644))
+
+		ratio, ok, err := newestPackSizeRatio(dir)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.InDelta(t, 0.5, ratio, 0.0001)
+	})
+}
+
+func writePack(t *testing.T, dir, name string, size int, modTime time.Time) {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, make([]byte, size), 0o644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+// TestCruftPackStatsEndToEnd exercises cruftPackStats and countPackObjects
+// against a repository repacked with `git repack --cruft` by the real git
+// binary, to pin down the .mtimes-sibling-file detection this package relies
+// on to tell a cruft pack apart from a normal one.
+func TestCruftPackStatsEndToEnd(t *testing.T) {
+	requireGit(t)
+	t.Parallel()
+
+	dir := t.TempDir()
+	runGit(t, dir, "init", "--quiet", "-b", "main", ".")
+	runGit(t, dir, "config", "user.email", "test@example.com")
+	runGit(t, dir, "config", "user.name", "Test")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("hello\n"), 0o644))
+	runGit(t, dir, "add", "file.txt")
+	runGit(t, dir, "commit", "--quiet", "-m", "initial")
+
+	// Create an unreachable blob so the cruft repack has something to put
+	// in the cruft pack.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "gone.txt"), []byte("unreachable\n"), 0o644))
+	blobOID := runGitOutput(t, dir, "hash-object", "-w", filepath.Join(dir, "gone.txt"))
+	require.NotEmpty(t, blobOID)
+
+	runGit(t, dir, "repack", "--cruft", "-d")
+
+	packDir := filepath.Join(dir, ".git", "objects", "pack")
+	size, objects, err := cruftPackStats(packDir)
+	require.NoError(t, err)
+	require.Greater(t, size, int64(0))
+	require.GreaterOrEqual(t, objects, 1)
+}
+
+func TestNewestPackName(t *testing.T) {
+	t.Parallel()
+
+	t.Run("missing directory", func(t *testing.T) {
+		t.Parallel()
+
+		_, ok, err := newestPackName(filepath.Join(t.TempDir(), "does-not-exist"))
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("no packs", func(t *testing.T) {
+		t.Parallel()
+
+		_, ok, err := newestPackName(t.TempDir())
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("picks the most recently written pack", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		now := time.Now()
+		writePack(t, dir, "pack-old.pack", 100, now.Add(-time.Hour))
+		writePack(t, dir, "pack-new.pack", 100, now)
+
+		name, ok, err := newestPackName(dir)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "pack-new.pack", name)
+	})
+}
+
+func TestMidxStats(t *testing.T) {
+	t.Parallel()
+
+	t.Run("no multi-pack-index written yet", func(t *testing.T) {
+		t.Parallel()
+
+		size, packCount, err := midxStats(t.TempDir())
+		require.NoError(t, err)
+		require.Zero(t, size)
+		require.Zero(t, packCount)
+	})
+
+	t.Run("reports size and the number of packs it sits alongside", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		writePack(t, dir, "pack-a.pack", 100, time.Now())
+		writePack(t, dir, "pack-b.pack", 100, time.Now())
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "multi-pack-index"), make([]byte, 42), 0o644))
+
+		size, packCount, err := midxStats(dir)
+		require.NoError(t, err)
+		require.Equal(t, int64(42), size)
+		require.Equal(t, 2, packCount)
+	})
+}
+
+// TestMidxRepackStrategyEndToEnd exercises the same `git repack -adb` +
+// `git multi-pack-index write --bitmap --preferred-pack=...` +
+// `git multi-pack-index verify` sequence MidxRepackStrategy.Repack runs,
+// directly against the real git binary, and asserts the resulting
+// multi-pack-index and its bitmap are present. It doesn't drive
+// MidxRepackStrategy.Repack or ObjectPool.FetchFromOrigin themselves:
+// ObjectPool's constructor isn't available in this checkout (see
+// fsck_test.go's end-to-end test for the same caveat).
+func TestMidxRepackStrategyEndToEnd(t *testing.T) {
+	requireGit(t)
+	t.Parallel()
+
+	dir := t.TempDir()
+	runGit(t, dir, "init", "--quiet", "-b", "main", ".")
+	runGit(t, dir, "config", "user.email", "test@example.com")
+	runGit(t, dir, "config", "user.name", "Test")
+
+	// Create at least 3 packs by committing and repacking loosely in
+	// between, so the pool isn't already a single pack before midx runs.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.txt", i)), []byte("content\n"), 0o644))
+		runGit(t, dir, "add", fmt.Sprintf("file%d.txt", i))
+		runGit(t, dir, "commit", "--quiet", "-m", fmt.Sprintf("commit %d", i))
+		runGit(t, dir, "repack", "-d", "-q")
+	}
+
+	packDir := filepath.Join(dir, ".git", "objects", "pack")
+
+	runGit(t, dir, "repack", "-adb")
+
+	// Like MidxRepackStrategy.Repack, pick the preferred pack only after
+	// `git repack -adb` has written it.
+	preferredPack, ok, err := newestPackName(packDir)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	runGit(t, dir, "-c", "pack.writeBitmapLookupTable=true", "multi-pack-index", "write", "--bitmap", "--preferred-pack="+preferredPack)
+	runGit(t, dir, "multi-pack-index", "verify")
+
+	entries, err := os.ReadDir(packDir)
+	require.NoError(t, err)
+
+	var sawMidx, sawBitmap bool
+	for _, entry := range entries {
+		switch {
+		case entry.Name() == "multi-pack-index":
+			sawMidx = true
+		case strings.HasPrefix(entry.Name(), "multi-pack-index-") && filepath.Ext(entry.Name()) == ".bitmap":
+			sawBitmap = true
+		}
+	}
+	require.True(t, sawMidx, "expected a multi-pack-index file")
+	require.True(t, sawBitmap, "expected a multi-pack-index bitmap file")
+
+	size, packCount, err := midxStats(packDir)
+	require.NoError(t, err)
+	require.Greater(t, size, int64(0))
+	require.Equal(t, 1, packCount)
+}
+
+func TestCountPackObjects(t *testing.T) {
+	requireGit(t)
+	t.Parallel()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+
+	dir := t.TempDir()
+	runGit(t, dir, "init", "--quiet", "-b", "main", ".")
+	runGit(t, dir, "config", "user.email", "test@example.com")
+	runGit(t, dir, "config", "user.name", "Test")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("hello\n"), 0o644))
+	runGit(t, dir, "add", "file.txt")
+	runGit(t, dir, "commit", "--quiet", "-m", "initial")
+	runGit(t, dir, "repack", "-ad")
+
+	packDir := filepath.Join(dir, ".git", "objects", "pack")
+	entries, err := os.ReadDir(packDir)
+	require.NoError(t, err)
+
+	var idxPath string
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".idx" {
+			idxPath = filepath.Join(packDir, entry.Name())
+		}
+	}
+	require.NotEmpty(t, idxPath)
+
+	count, err := countPackObjects(idxPath)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, count, 3)
+}
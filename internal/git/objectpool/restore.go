@@ -0,0 +1,175 @@
+package objectpool
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// RestoreBackup reads a tar stream produced by WriteBackup and reconstructs
+// poolPath from it: it extracts into a temporary directory under
+// filepath.Dir(poolPath), validates the result with
+// `git fsck --connectivity-only`, and only then moves it into place at
+// poolPath. If poolPath already exists it is removed first, since this is
+// meant to be used to recover a pool that is already known to be gone or
+// unusable; that removal, unlike the final rename, is not itself atomic.
+func RestoreBackup(ctx context.Context, r io.Reader, poolPath string) error {
+	tempDir, err := os.MkdirTemp(filepath.Dir(poolPath), ".restore-*")
+	if err != nil {
+		return fmt.Errorf("create restore directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := os.MkdirAll(filepath.Join(tempDir, "objects", "pack"), 0o755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(tempDir, "refs"), 0o755); err != nil {
+		return err
+	}
+
+	manifest, err := extractBackup(r, tempDir)
+	if err != nil {
+		return fmt.Errorf("extract backup: %w", err)
+	}
+
+	if err := validateManifest(ctx, tempDir, manifest); err != nil {
+		return fmt.Errorf("validate manifest: %w", err)
+	}
+
+	if err := verifyConnectivity(ctx, tempDir); err != nil {
+		return fmt.Errorf("restored pool failed connectivity check: %w", err)
+	}
+
+	if _, err := os.Stat(poolPath); err == nil {
+		if err := os.RemoveAll(poolPath); err != nil {
+			return fmt.Errorf("remove existing pool: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Rename(tempDir, poolPath)
+}
+
+// extractBackup writes every file in the tar stream read from r into
+// destDir, and returns the decoded BackupManifest. It expects the manifest
+// to be the first entry, matching how WriteBackup writes the stream.
+func extractBackup(r io.Reader, destDir string) (BackupManifest, error) {
+	tr := tar.NewReader(r)
+
+	header, err := tr.Next()
+	if err != nil {
+		return BackupManifest{}, fmt.Errorf("read manifest entry: %w", err)
+	}
+	if header.Name != manifestName {
+		return BackupManifest{}, fmt.Errorf("expected first tar entry to be %s, got %s", manifestName, header.Name)
+	}
+
+	var manifest BackupManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return BackupManifest{}, fmt.Errorf("decode manifest: %w", err)
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return BackupManifest{}, err
+		}
+
+		destPath, err := safeJoin(destDir, header.Name)
+		if err != nil {
+			return BackupManifest{}, err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+			return BackupManifest{}, err
+		}
+
+		file, err := os.OpenFile(destPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return BackupManifest{}, err
+		}
+
+		_, copyErr := io.Copy(file, tr)
+		closeErr := file.Close()
+		if copyErr != nil {
+			return BackupManifest{}, copyErr
+		}
+		if closeErr != nil {
+			return BackupManifest{}, closeErr
+		}
+	}
+
+	return manifest, nil
+}
+
+// safeJoin joins destDir and name, rejecting any name that would escape
+// destDir (e.g. via "../"), since the tar stream's entries are not
+// trusted.
+func safeJoin(destDir, name string) (string, error) {
+	joined := filepath.Join(destDir, name)
+	if joined != destDir && !strings.HasPrefix(joined, destDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("tar entry %q escapes destination directory", name)
+	}
+	return joined, nil
+}
+
+// validateManifest checks that manifest is consistent with what was
+// actually extracted into tempDir: the HEAD file extracted from the
+// backup's contents must match what the manifest recorded for it, and the
+// refs/remotes/origin refs extracted from packed-refs must match the
+// manifest's OriginRefs snapshot entry for entry. A backup's packed-refs
+// file is whatever the pool's refs/remotes/origin looked like at backup
+// time, so this also guards against a packed-refs file that was edited, or
+// swapped for a different pool's, after the backup was taken.
+func validateManifest(ctx context.Context, tempDir string, manifest BackupManifest) error {
+	if manifest.Head == "" {
+		return fmt.Errorf("manifest has no HEAD recorded")
+	}
+
+	head, err := os.ReadFile(filepath.Join(tempDir, "HEAD"))
+	if err != nil {
+		return fmt.Errorf("read extracted HEAD: %w", err)
+	}
+
+	if strings.TrimSpace(string(head)) != manifest.Head {
+		return fmt.Errorf("extracted HEAD %q does not match manifest HEAD %q", head, manifest.Head)
+	}
+
+	extractedOriginRefs, err := originRefs(ctx, tempDir)
+	if err != nil {
+		return fmt.Errorf("read extracted origin refs: %w", err)
+	}
+
+	if len(extractedOriginRefs) != len(manifest.OriginRefs) {
+		return fmt.Errorf("extracted %d origin refs, manifest recorded %d", len(extractedOriginRefs), len(manifest.OriginRefs))
+	}
+	for ref, oid := range manifest.OriginRefs {
+		if extractedOID, ok := extractedOriginRefs[ref]; !ok || extractedOID != oid {
+			return fmt.Errorf("extracted origin ref %q is %q, manifest recorded %q", ref, extractedOID, oid)
+		}
+	}
+
+	return nil
+}
+
+// verifyConnectivity runs `git fsck --connectivity-only` against gitDir to
+// confirm the restored packs and refs are internally consistent before
+// RestoreBackup commits to replacing poolPath with them.
+func verifyConnectivity(ctx context.Context, gitDir string) error {
+	cmd := exec.CommandContext(ctx, "git", "--git-dir", gitDir, "fsck", "--connectivity-only")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package git2go
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitaly/v14/internal/git"
+)
+
+// Executor dispatches subcommands such as Merge to the gitaly-git2go-v14
+// binary at the path given to NewExecutor.
+type Executor struct {
+	binaryPath    string
+	gitCmdFactory git.CommandFactory
+}
+
+// NewExecutor returns a new Executor which dispatches git2go subcommands to
+// the binary found at binaryPath.
+func NewExecutor(binaryPath string, gitCmdFactory git.CommandFactory) *Executor {
+	return &Executor{
+		binaryPath:    binaryPath,
+		gitCmdFactory: gitCmdFactory,
+	}
+}
+
+// run serializes request, invokes the given git2go subcommand with it and
+// deserializes its response into response.
+func (e *Executor) run(ctx context.Context, repoPath, subcmd string, request, response interface{}) error {
+	var stdin bytes.Buffer
+	if err := gob.NewEncoder(&stdin).Encode(request); err != nil {
+		return fmt.Errorf("serializing request: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.binaryPath, subcmd, "-repository", repoPath)
+	cmd.Stdin = &stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		var gobErr error
+		if decodeErr := gob.NewDecoder(&stdout).Decode(&gobErr); decodeErr == nil && gobErr != nil {
+			return gobErr
+		}
+
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %w", msg, err)
+		}
+		return fmt.Errorf("%s: %w", subcmd, err)
+	}
+
+	if err := gob.NewDecoder(&stdout).Decode(response); err != nil {
+		return fmt.Errorf("deserializing response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package git2go
+
+import (
+	"encoding/gob"
+	"fmt"
+)
+
+func init() {
+	gob.Register(ConflictingFilesError{})
+	gob.Register(InvalidArgumentError(""))
+	gob.Register(UnrelatedHistoriesError{})
+	gob.Register(AmbiguousMergeBaseError{})
+}
+
+// InvalidArgumentError is returned when the merge command was invoked with
+// missing or invalid parameters.
+type InvalidArgumentError string
+
+func (e InvalidArgumentError) Error() string {
+	return fmt.Sprintf("invalid parameters: %s", string(e))
+}
+
+// ConflictingFilesError is returned when a merge could not be completed due
+// to one or more conflicting files.
+type ConflictingFilesError struct {
+	// ConflictingFiles contains the paths of the files which have
+	// conflicted.
+	ConflictingFiles []string
+	// Conflicts carries the structured per-path conflict details
+	// described by MergeConflict. It is only populated when the request
+	// had MergeCommand.ConflictDetails set.
+	Conflicts []MergeConflict
+	// TheirsHead names the octopus head whose merge into the running
+	// index produced the conflict. Only set for octopus merges, where
+	// conflict resolution is not attempted and the first conflicting
+	// head aborts the whole merge.
+	TheirsHead string
+}
+
+func (e ConflictingFilesError) Error() string {
+	return "could not auto-merge due to conflicts"
+}
+
+// ConflictEntry describes a single merge-index stage (ancestor, our or
+// their side) of a conflicting path. A zero-value entry indicates that the
+// corresponding side had no entry for the path at all.
+type ConflictEntry struct {
+	// OID is the blob object ID recorded for this stage.
+	OID string
+	// Mode is the file mode recorded for this stage.
+	Mode uint32
+}
+
+// MergeConflict carries the structured details of a single conflicting path:
+// the three merge-index stages and, where libgit2 was able to produce one,
+// the merged file contents with conflict markers.
+type MergeConflict struct {
+	// Path is the path of the conflicting file.
+	Path string
+	// Ancestor, Our and Their are the merge-index stages recorded for
+	// Path.
+	Ancestor, Our, Their ConflictEntry
+	// Contents is the merged file with conflict markers, when libgit2
+	// was able to produce one.
+	Contents []byte
+}
+
+// UnrelatedHistoriesError is returned when Ours and Theirs do not share a
+// merge base and AllowUnrelatedHistories was not set.
+type UnrelatedHistoriesError struct {
+	// Ours is the commit ID of the merge's "ours" side.
+	Ours string
+	// Theirs is the commit ID of the merge's "theirs" side.
+	Theirs string
+}
+
+func (e UnrelatedHistoriesError) Error() string {
+	return fmt.Sprintf("refusing to merge unrelated histories: %s and %s", e.Ours, e.Theirs)
+}
+
+// AmbiguousMergeBaseError is returned when Ours and Theirs have more than
+// one merge base and the merge was configured with VirtualBaseFailOnMultiple
+// rather than having libgit2 guess at a virtual base.
+type AmbiguousMergeBaseError struct {
+	// Ours is the commit ID of the merge's "ours" side.
+	Ours string
+	// Theirs is the commit ID of the merge's "theirs" side.
+	Theirs string
+	// Candidates lists the object IDs of the candidate merge bases.
+	Candidates []string
+}
+
+func (e AmbiguousMergeBaseError) Error() string {
+	return fmt.Sprintf("ambiguous merge base between %s and %s: %d candidates", e.Ours, e.Theirs, len(e.Candidates))
+}
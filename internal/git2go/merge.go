@@ -0,0 +1,166 @@
+package git2go
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitlab.com/gitlab-org/gitaly/v14/internal/git/repository"
+)
+
+// MergeRecursionLimit is the default recursion limit used by libgit2 when it
+// has to fall back to computing a virtual merge base for criss-cross
+// merges. It bounds the otherwise exponential cost of resolving ambiguous
+// merge bases. MergeCommand.RecursionLimit overrides it per request.
+const MergeRecursionLimit = 20
+
+// VirtualBaseStrategy controls how a merge resolves Ours and Theirs having
+// more than one common ancestor, as happens with criss-cross merges.
+type VirtualBaseStrategy int
+
+const (
+	// VirtualBaseRecursive has libgit2 compute a virtual merge base by
+	// recursively merging the candidate bases together, up to
+	// RecursionLimit. This is the current, default behavior.
+	VirtualBaseRecursive VirtualBaseStrategy = iota
+	// VirtualBasePickFirst skips virtual-base computation and
+	// deterministically uses the first candidate base instead, trading
+	// correctness in pathological histories for predictable cost.
+	VirtualBasePickFirst
+	// VirtualBaseFailOnMultiple refuses to guess at a merge base and
+	// returns an AmbiguousMergeBaseError listing the candidates, leaving
+	// resolution up to the caller.
+	VirtualBaseFailOnMultiple
+)
+
+// MergeCommand contains parameters to perform a merge.
+type MergeCommand struct {
+	// Repository is the path of the repository the merge should be
+	// performed in.
+	Repository string
+	// AuthorName is the author name of the merge commit.
+	AuthorName string
+	// AuthorMail is the author mail of the merge commit.
+	AuthorMail string
+	// AuthorDate is the author date of the merge commit.
+	AuthorDate time.Time
+	// CommitterName is the committer name of the merge commit. May be
+	// left empty, in which case it is set to AuthorName.
+	CommitterName string
+	// CommitterMail is the committer mail of the merge commit. May be
+	// left empty, in which case it is set to AuthorMail.
+	CommitterMail string
+	// CommitterDate is the committer date of the merge commit. May be
+	// left empty, in which case it is set to AuthorDate.
+	CommitterDate time.Time
+	// Message is the message to be used for the merge commit.
+	Message string
+	// Ours is the commit into which Theirs is to be merged. It becomes
+	// the first parent of the merge commit.
+	Ours string
+	// Theirs is the commit that is to be merged into Ours.
+	Theirs string
+	// TheirsRefs, when non-empty, requests an octopus merge: each of the
+	// listed commits is folded onto Ours in turn, producing a single
+	// commit with len(TheirsRefs)+1 parents. It takes precedence over
+	// Theirs. Octopus semantics forbid conflict resolution: the first
+	// pairwise conflict aborts the whole merge.
+	TheirsRefs []string
+	// Squash causes the merge to generate a tree and parent identical to
+	// a regular merge, but commits the tree with Ours as its sole
+	// parent.
+	Squash bool
+	// AllowUnrelatedHistories causes the merge to proceed even when Ours
+	// and Theirs do not share a merge base. The merge is then performed
+	// against an empty tree. When unset, such a merge fails with an
+	// UnrelatedHistoriesError.
+	AllowUnrelatedHistories bool
+	// ConflictDetails causes a failing merge to return a
+	// ConflictingFilesError whose Conflicts field carries the structured
+	// per-path MergeConflict details instead of only the conflicting
+	// paths. Defaults to off to preserve the existing, cheaper behavior.
+	ConflictDetails bool
+	// DryRun runs the merge machinery up through index construction and
+	// reports either the resulting tree OID or a ConflictingFilesError,
+	// but never writes a commit. Author, committer and Message are not
+	// required when DryRun is set.
+	DryRun bool
+	// RecursionLimit overrides MergeRecursionLimit for this merge. Zero
+	// keeps the default.
+	RecursionLimit int
+	// VirtualBaseStrategy controls how an ambiguous merge base is
+	// resolved. The zero value is VirtualBaseRecursive.
+	VirtualBaseStrategy VirtualBaseStrategy
+}
+
+// MergeResult contains results from a merge.
+type MergeResult struct {
+	// CommitID is the object ID of the generated merge commit. Left
+	// empty for a MergeCommand with DryRun set.
+	CommitID string
+	// TreeID is the object ID of the merged tree. Only populated for a
+	// MergeCommand with DryRun set, where no commit is created.
+	TreeID string
+}
+
+// Merge performs a merge via gitaly-git2go-v14.
+func (e *Executor) Merge(ctx context.Context, repo repository.GitRepo, m MergeCommand) (MergeResult, error) {
+	if err := m.verify(); err != nil {
+		return MergeResult{}, fmt.Errorf("merge: %w", err)
+	}
+
+	repoPath, err := repo.Path()
+	if err != nil {
+		return MergeResult{}, fmt.Errorf("merge: %w", err)
+	}
+
+	var response MergeResult
+	if err := e.run(ctx, repoPath, "merge", m, &response); err != nil {
+		return MergeResult{}, fmt.Errorf("merge: %w", err)
+	}
+
+	return response, nil
+}
+
+func (m MergeCommand) verify() error {
+	if m.Repository == "" {
+		return InvalidArgumentError("missing repository")
+	}
+	if m.Ours == "" {
+		return InvalidArgumentError("missing ours")
+	}
+	if m.Theirs == "" && len(m.TheirsRefs) == 0 {
+		return InvalidArgumentError("missing theirs")
+	}
+
+	// DryRun never writes a commit, so the commit-authoring fields below
+	// are not required.
+	if m.DryRun {
+		return nil
+	}
+
+	if m.AuthorName == "" {
+		return InvalidArgumentError("missing author name")
+	}
+	if m.AuthorMail == "" {
+		return InvalidArgumentError("missing author mail")
+	}
+	if m.Message == "" {
+		return InvalidArgumentError("missing message")
+	}
+
+	// Committer* arguments are required only when at least one of them is non-empty.
+	if m.CommitterName != "" || m.CommitterMail != "" || !m.CommitterDate.IsZero() {
+		if m.CommitterName == "" {
+			return InvalidArgumentError("missing committer name")
+		}
+		if m.CommitterMail == "" {
+			return InvalidArgumentError("missing committer mail")
+		}
+		if m.CommitterDate.IsZero() {
+			return InvalidArgumentError("missing committer date")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"regexp"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// CertIdentityAllowlist decides whether a client certificate presented over
+// mTLS is allowed to call the server at all, independent of and in addition
+// to the RPC's usual token auth. A certificate matches if its subject CN
+// matches CommonNamePattern (when set) or if one of its URI SANs is present
+// in SANURIs (when non-empty). An empty allowlist matches nothing, so
+// enabling mTLS without configuring one denies every client.
+type CertIdentityAllowlist struct {
+	// CommonNamePattern, if set, is matched against the leaf
+	// certificate's subject CommonName.
+	CommonNamePattern *regexp.Regexp
+	// SANURIs, if non-empty, is the set of URI SANs that are allowed to
+	// connect. A certificate matches if any of its URI SANs appears here.
+	SANURIs map[string]struct{}
+}
+
+func (a CertIdentityAllowlist) matches(cert *x509.Certificate) bool {
+	if a.CommonNamePattern != nil && a.CommonNamePattern.MatchString(cert.Subject.CommonName) {
+		return true
+	}
+
+	for _, uri := range cert.URIs {
+		if _, ok := a.SANURIs[uri.String()]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CertIdentityAuthenticator rejects RPCs whose client certificate, as
+// verified by the mTLS handshake, doesn't match Allowlist. It is only
+// meaningful on a listener configured with
+// tls.Config{ClientAuth: tls.RequireAndVerifyClientCert}; callers that
+// aren't using mTLS at all should not install it.
+type CertIdentityAuthenticator struct {
+	Allowlist CertIdentityAllowlist
+}
+
+// authenticate extracts the verified leaf client certificate from ctx's
+// peer info and checks it against the allowlist.
+func (a CertIdentityAuthenticator) authenticate(ctx context.Context) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.PermissionDenied, "cert identity: no peer information in context")
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return status.Error(codes.PermissionDenied, "cert identity: connection is not using TLS")
+	}
+
+	if len(tlsInfo.State.PeerCertificates) == 0 {
+		return status.Error(codes.PermissionDenied, "cert identity: no client certificate presented")
+	}
+
+	leaf := tlsInfo.State.PeerCertificates[0]
+	if !a.Allowlist.matches(leaf) {
+		return status.Errorf(codes.PermissionDenied, "cert identity: certificate %q is not in the allowlist", leaf.Subject.CommonName)
+	}
+
+	return nil
+}
+
+// UnaryInterceptor returns a grpc.UnaryServerInterceptor that enforces the
+// allowlist before the RPC handler runs.
+func (a CertIdentityAuthenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := a.authenticate(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor returns a grpc.StreamServerInterceptor that enforces the
+// allowlist before the RPC handler runs.
+func (a CertIdentityAuthenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := a.authenticate(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// clientCATLSConfig builds the server-side tls.Config for the server's own
+// certificate with the given clientAuth policy. Unless clientAuth is
+// tls.NoClientCert, client certificates are verified against the CAs in
+// clientCAPath; with tls.NoClientCert, clientCAPath is not read. This is
+// split out from server.New's own TLS
+// setup (not present in this checkout) so that it can be unit tested without
+// needing the rest of the server bootstrap.
+func clientCATLSConfig(serverCert tls.Certificate, clientCAPath string, clientAuth tls.ClientAuthType) (*tls.Config, error) {
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   clientAuth,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientAuth == tls.NoClientCert {
+		return cfg, nil
+	}
+
+	pem, err := os.ReadFile(clientCAPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if ok := pool.AppendCertsFromPEM(pem); !ok {
+		return nil, errInvalidClientCA{path: clientCAPath}
+	}
+	cfg.ClientCAs = pool
+
+	return cfg, nil
+}
+
+type errInvalidClientCA struct{ path string }
+
+func (e errInvalidClientCA) Error() string {
+	return "no valid certificates found in client CA file: " + e.path
+}
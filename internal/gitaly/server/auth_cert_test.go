@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestCertIdentityAllowlist_matches(t *testing.T) {
+	t.Parallel()
+
+	sanCert := selfSignedCert(t, "irrelevant-cn", "spiffe://gitaly/worker-1")
+
+	testCases := []struct {
+		desc      string
+		allowlist CertIdentityAllowlist
+		cert      *x509.Certificate
+		matches   bool
+	}{
+		{
+			desc:      "matching common name",
+			allowlist: CertIdentityAllowlist{CommonNamePattern: regexp.MustCompile(`^worker-\d+$`)},
+			cert:      selfSignedCert(t, "worker-1"),
+			matches:   true,
+		},
+		{
+			desc:      "non-matching common name",
+			allowlist: CertIdentityAllowlist{CommonNamePattern: regexp.MustCompile(`^worker-\d+$`)},
+			cert:      selfSignedCert(t, "attacker"),
+			matches:   false,
+		},
+		{
+			desc:      "matching SAN URI",
+			allowlist: CertIdentityAllowlist{SANURIs: map[string]struct{}{"spiffe://gitaly/worker-1": {}}},
+			cert:      sanCert,
+			matches:   true,
+		},
+		{
+			desc:      "non-matching SAN URI",
+			allowlist: CertIdentityAllowlist{SANURIs: map[string]struct{}{"spiffe://gitaly/worker-2": {}}},
+			cert:      sanCert,
+			matches:   false,
+		},
+		{
+			desc:      "empty allowlist denies everything",
+			allowlist: CertIdentityAllowlist{},
+			cert:      selfSignedCert(t, "worker-1"),
+			matches:   false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tc.matches, tc.allowlist.matches(tc.cert))
+		})
+	}
+}
+
+func TestCertIdentityAuthenticator_authenticate(t *testing.T) {
+	t.Parallel()
+
+	authenticator := CertIdentityAuthenticator{
+		Allowlist: CertIdentityAllowlist{CommonNamePattern: regexp.MustCompile(`^worker-1$`)},
+	}
+
+	t.Run("no peer in context", func(t *testing.T) {
+		t.Parallel()
+		err := authenticator.authenticate(context.Background())
+		testRequireCode(t, err, codes.PermissionDenied)
+	})
+
+	t.Run("peer without TLS info", func(t *testing.T) {
+		t.Parallel()
+		ctx := peer.NewContext(context.Background(), &peer.Peer{})
+		err := authenticator.authenticate(ctx)
+		testRequireCode(t, err, codes.PermissionDenied)
+	})
+
+	t.Run("no client certificate presented", func(t *testing.T) {
+		t.Parallel()
+		ctx := peerContextWithCerts(t)
+		err := authenticator.authenticate(ctx)
+		testRequireCode(t, err, codes.PermissionDenied)
+	})
+
+	t.Run("certificate with wrong common name", func(t *testing.T) {
+		t.Parallel()
+		ctx := peerContextWithCerts(t, selfSignedCert(t, "attacker"))
+		err := authenticator.authenticate(ctx)
+		testRequireCode(t, err, codes.PermissionDenied)
+	})
+
+	t.Run("certificate with allowlisted common name", func(t *testing.T) {
+		t.Parallel()
+		ctx := peerContextWithCerts(t, selfSignedCert(t, "worker-1"))
+		require.NoError(t, authenticator.authenticate(ctx))
+	})
+}
+
+func TestClientCATLSConfig(t *testing.T) {
+	t.Parallel()
+
+	caCert := selfSignedCert(t, "test-ca")
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caPath, pemEncodeCert(caCert), 0o644))
+
+	serverCert := tls.Certificate{Certificate: [][]byte{caCert.Raw}}
+
+	t.Run("client auth disabled leaves ClientCAs unset", func(t *testing.T) {
+		t.Parallel()
+
+		tlsConfig, err := clientCATLSConfig(serverCert, caPath, tls.NoClientCert)
+		require.NoError(t, err)
+		require.Nil(t, tlsConfig.ClientCAs)
+		require.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+	})
+
+	t.Run("client auth enabled loads the CA pool", func(t *testing.T) {
+		t.Parallel()
+
+		tlsConfig, err := clientCATLSConfig(serverCert, caPath, tls.RequireAndVerifyClientCert)
+		require.NoError(t, err)
+		require.NotNil(t, tlsConfig.ClientCAs)
+		require.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+	})
+
+	t.Run("missing CA file", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := clientCATLSConfig(serverCert, filepath.Join(t.TempDir(), "missing.pem"), tls.RequireAndVerifyClientCert)
+		require.Error(t, err)
+	})
+
+	t.Run("CA file with no valid certificates", func(t *testing.T) {
+		t.Parallel()
+
+		badPath := filepath.Join(t.TempDir(), "bad.pem")
+		require.NoError(t, os.WriteFile(badPath, []byte("not a cert"), 0o644))
+
+		_, err := clientCATLSConfig(serverCert, badPath, tls.RequireAndVerifyClientCert)
+		require.Error(t, err)
+	})
+}
+
+func testRequireCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	require.Error(t, err)
+	require.Equal(t, code, status.Code(err))
+}
+
+func peerContextWithCerts(t *testing.T, certs ...*x509.Certificate) context.Context {
+	t.Helper()
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{PeerCertificates: certs},
+		},
+	})
+}
+
+func selfSignedCert(t *testing.T, commonName string, sanURIs ...string) *x509.Certificate {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	for _, raw := range sanURIs {
+		uri, err := url.Parse(raw)
+		require.NoError(t, err)
+		template.URIs = append(template.URIs, uri)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return cert
+}
+
+func pemEncodeCert(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
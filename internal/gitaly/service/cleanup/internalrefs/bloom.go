@@ -0,0 +1,85 @@
+package internalrefs
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// bloomFilter is a small, fixed-size Bloom filter used to cheaply rule out
+// the common case where a rewritten OID has no internal references pointing
+// at it, avoiding a disk seek into the sorted lookup file for every entry of
+// a large object map.
+//
+// It trades a configurable false-positive rate (an unnecessary seek) for a
+// memory footprint that stays bounded regardless of how many internal refs
+// the repository has.
+type bloomFilter struct {
+	bits    []uint64
+	numBits uint64
+	numHash uint64
+}
+
+// newBloomFilter builds a Bloom filter sized for n elements at the given
+// target false-positive rate.
+func newBloomFilter(n int, falsePositiveRate float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+
+	numBits := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if numBits < 64 {
+		numBits = 64
+	}
+
+	numHash := uint64(math.Round(float64(numBits) / float64(n) * math.Ln2))
+	if numHash < 1 {
+		numHash = 1
+	} else if numHash > 16 {
+		numHash = 16
+	}
+
+	return &bloomFilter{
+		bits:    make([]uint64, (numBits+63)/64),
+		numBits: numBits,
+		numHash: numHash,
+	}
+}
+
+// Add records oid as present in the filter.
+func (b *bloomFilter) Add(oid string) {
+	h1, h2 := bloomHashes(oid)
+	for i := uint64(0); i < b.numHash; i++ {
+		bit := (h1 + i*h2) % b.numBits
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// MaybeContains reports whether oid might be present. A false return is
+// definitive; a true return means a lookup against the real data is
+// required to confirm.
+func (b *bloomFilter) MaybeContains(oid string) bool {
+	h1, h2 := bloomHashes(oid)
+	for i := uint64(0); i < b.numHash; i++ {
+		bit := (h1 + i*h2) % b.numBits
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHashes derives a pair of independent hashes from oid. Combining them
+// linearly (double hashing) is a well-known way to simulate numHash
+// independent hash functions without running numHash real ones.
+func bloomHashes(oid string) (uint64, uint64) {
+	h1 := fnv.New64a()
+	_, _ = h1.Write([]byte(oid))
+
+	h2 := fnv.New64()
+	_, _ = h2.Write([]byte(oid))
+
+	return h1.Sum64(), h2.Sum64()
+}
@@ -13,21 +13,78 @@ import (
 	"gitlab.com/gitlab-org/gitaly/v14/internal/git/updateref"
 )
 
+// CleanerAction describes what a Cleaner actually did with an internal
+// reference while processing an object map entry.
+type CleanerAction string
+
+const (
+	// ActionNone means the entry did not reference any internal ref, so
+	// no action was taken.
+	ActionNone CleanerAction = "none"
+	// ActionDeleted means the internal ref was removed.
+	ActionDeleted CleanerAction = "deleted"
+	// ActionRewritten means the internal ref was updated in place to
+	// point at the rewritten object.
+	ActionRewritten CleanerAction = "rewritten"
+)
+
 // A ForEachFunc can be called for every entry in the filter-repo or BFG object
-// map file that the cleaner is processing. Returning an error will stop the
-// cleaner before it has processed the entry in question
-type ForEachFunc func(ctx context.Context, oldOID, newOID string, isInternalRef bool) error
+// map file that the cleaner is processing. action reports what the cleaner
+// did about any internal refs pointing at oldOID. For an entry with internal
+// refs it is called after that entry's ref updates have been queued.
+// Returning an error stops the cleaner, and ApplyObjectMap returns it
+// without committing the queued ref updates.
+type ForEachFunc func(ctx context.Context, oldOID, newOID string, isInternalRef bool, action CleanerAction) error
+
+// oidHexLen maps a repository's object-format name, as reported by `git
+// rev-parse --show-object-format`, to the length of its hex-encoded object
+// IDs.
+var oidHexLen = map[string]int{
+	"sha1":   40,
+	"sha256": 64,
+}
+
+// CleanerMode controls how the Cleaner reacts to an internal reference that
+// points at a rewritten object.
+type CleanerMode int
+
+const (
+	// ModeDelete removes internal references pointing at a rewritten
+	// object. This is the original behavior of the Cleaner, and remains
+	// the default.
+	ModeDelete CleanerMode = iota
+	// ModeRewrite updates internal references to point at the new object
+	// ID instead of removing them, preserving the history that the
+	// caller asked filter-repo/BFG to keep. An object map entry whose
+	// new OID is the zero OID is BFG's convention for "object removed",
+	// and is always deleted rather than rewritten. If the new OID does
+	// not exist in the repository, ApplyObjectMap returns an error
+	// instead of rewriting the reference to a dangling object.
+	ModeRewrite
+	// ModeRewriteOrDelete behaves like ModeRewrite, except that an
+	// internal reference is deleted, rather than rejected, when the new
+	// OID does not exist in the repository.
+	ModeRewriteOrDelete
+)
 
 // Cleaner is responsible for updating the internal references in a repository
-// as specified by a filter-repo or BFG object map. Currently, internal
-// references pointing to a commit that has been rewritten will simply be
-// removed.
+// as specified by a filter-repo or BFG object map. Depending on its mode, an
+// internal reference pointing at a rewritten object is either removed or
+// updated to point at the new object.
 type Cleaner struct {
 	ctx     context.Context
+	repo    git.RepositoryExecutor
+	mode    CleanerMode
 	forEach ForEachFunc
 
-	// Map of SHA -> reference names
-	table   map[string][]git.ReferenceName
+	// hexLen is the length of a hex-encoded object ID in this
+	// repository: 40 for SHA-1, 64 for SHA-256.
+	hexLen int
+
+	// table maps SHA -> reference names. For repositories with a huge
+	// number of internal refs it is backed by disk rather than held
+	// fully in memory; see buildLookupTable.
+	table   lookupTable
 	updater *updateref.Updater
 }
 
@@ -35,24 +92,103 @@ type Cleaner struct {
 // map file is in the wrong format
 type ErrInvalidObjectMap error
 
+// CleanerOption configures optional, rarely-tuned parameters of a Cleaner.
+type CleanerOption func(*cleanerConfig)
+
+type cleanerConfig struct {
+	lookupThreshold int
+}
+
+// WithLookupThreshold overrides the number of internal refs at which
+// NewCleaner builds a disk-backed, rather than in-memory, lookup table. It
+// exists mainly so tests can exercise the disk-backed path without needing a
+// repository with a million internal refs.
+func WithLookupThreshold(threshold int) CleanerOption {
+	return func(c *cleanerConfig) {
+		c.lookupThreshold = threshold
+	}
+}
+
 // NewCleaner builds a new instance of Cleaner, which is used to apply a
-// filter-repo or BFG object map to a repository.
-func NewCleaner(ctx context.Context, repo git.RepositoryExecutor, forEach ForEachFunc) (*Cleaner, error) {
-	table, err := buildLookupTable(ctx, repo)
+// filter-repo or BFG object map to a repository. It supports both SHA-1 and
+// SHA-256 repositories, detecting the hash algorithm in use from repo. mode
+// controls whether internal references pointing at a rewritten object are
+// deleted or updated in place.
+//
+// Callers should call Close once they are done with the returned Cleaner, to
+// release any temporary files created for repositories with very large
+// numbers of internal refs.
+func NewCleaner(ctx context.Context, repo git.RepositoryExecutor, mode CleanerMode, forEach ForEachFunc, opts ...CleanerOption) (*Cleaner, error) {
+	var cfg cleanerConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	hexLen, err := detectHexLen(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := buildLookupTable(ctx, repo, hexLen, cfg.lookupThreshold)
 	if err != nil {
 		return nil, err
 	}
 
 	updater, err := updateref.New(ctx, repo)
 	if err != nil {
+		_ = table.Close()
 		return nil, err
 	}
 
-	return &Cleaner{ctx: ctx, table: table, updater: updater, forEach: forEach}, nil
+	return &Cleaner{ctx: ctx, repo: repo, mode: mode, table: table, hexLen: hexLen, updater: updater, forEach: forEach}, nil
+}
+
+// Close releases any temporary resources held by the Cleaner's lookup table.
+func (c *Cleaner) Close() error {
+	return c.table.Close()
+}
+
+// zeroOID is BFG's convention for "object removed": an object map entry
+// mapping the old OID to hexLen zeroes means the object was stripped from
+// history entirely, rather than rewritten to a new OID.
+func zeroOID(hexLen int) string {
+	return strings.Repeat("0", hexLen)
+}
+
+// detectHexLen determines the length of a hex-encoded object ID in repo by
+// asking Git for its object format.
+func detectHexLen(ctx context.Context, repo git.RepositoryExecutor) (int, error) {
+	cmd, err := repo.Exec(ctx, git.SubCmd{
+		Name: "rev-parse",
+		Flags: []git.Option{
+			git.Flag{Name: "--show-object-format"},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	output, err := io.ReadAll(cmd)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return 0, err
+	}
+
+	format := strings.TrimSpace(string(output))
+	hexLen, ok := oidHexLen[format]
+	if !ok {
+		return 0, fmt.Errorf("unsupported object format: %q", format)
+	}
+
+	return hexLen, nil
 }
 
 // ApplyObjectMap processes an object map file generated by git filter-repo, or
-// BFG, removing any internal references that point to a rewritten commit.
+// BFG, deleting or rewriting any internal references that point to a
+// rewritten commit, depending on the Cleaner's mode.
 func (c *Cleaner) ApplyObjectMap(ctx context.Context, reader io.Reader) error {
 	scanner := bufio.NewScanner(reader)
 	for i := int64(0); scanner.Scan(); i++ {
@@ -64,12 +200,11 @@ func (c *Cleaner) ApplyObjectMap(ctx context.Context, reader io.Reader) error {
 		}
 
 		// Each line consists of two SHAs: the SHA of the original object, and
-		// the SHA of a replacement object in the new repository history. For
-		// now, the new SHA is ignored, but it may be used to rewrite (rather
-		// than remove) some references in the future.
+		// the SHA of a replacement object in the new repository history. BFG
+		// uses the zero OID as the new SHA to mean "object removed".
 		shas := strings.SplitN(line, " ", 2)
 
-		if len(shas) != 2 || len(shas[0]) != 40 || len(shas[1]) != 40 {
+		if len(shas) != 2 || len(shas[0]) != c.hexLen || len(shas[1]) != c.hexLen {
 			return ErrInvalidObjectMap(fmt.Errorf("object map invalid at line %d", i))
 		}
 
@@ -89,26 +224,26 @@ func (c *Cleaner) ApplyObjectMap(ctx context.Context, reader io.Reader) error {
 }
 
 func (c *Cleaner) processEntry(ctx context.Context, oldSHA, newSHA string) error {
-	refs, isPresent := c.table[oldSHA]
-
-	if c.forEach != nil {
-		if err := c.forEach(ctx, oldSHA, newSHA, isPresent); err != nil {
-			return err
-		}
+	refs, err := c.table.Lookup(oldSHA)
+	if err != nil {
+		return err
 	}
+	isPresent := len(refs) > 0
 
 	if !isPresent {
+		if c.forEach != nil {
+			return c.forEach(ctx, oldSHA, newSHA, false, ActionNone)
+		}
 		return nil
 	}
 
-	ctxlogrus.Extract(c.ctx).WithFields(log.Fields{
-		"sha":  oldSHA,
-		"refs": refs,
-	}).Info("removing internal references")
+	action, err := c.updateRefs(ctx, oldSHA, newSHA, refs)
+	if err != nil {
+		return err
+	}
 
-	// Remove the internal refs pointing to oldSHA
-	for _, ref := range refs {
-		if err := c.updater.Delete(ref); err != nil {
+	if c.forEach != nil {
+		if err := c.forEach(ctx, oldSHA, newSHA, true, action); err != nil {
 			return err
 		}
 	}
@@ -116,45 +251,74 @@ func (c *Cleaner) processEntry(ctx context.Context, oldSHA, newSHA string) error
 	return nil
 }
 
-// buildLookupTable constructs an in-memory map of SHA -> refs. Multiple refs
-// may point to the same SHA.
-//
-// The lookup table is necessary to efficiently check which references point to
-// an object that has been rewritten by the filter-repo or BFG (and so require
-// action). It is consulted once per line in the object map. Git is optimized
-// for ref -> SHA lookups, but we want the opposite!
-func buildLookupTable(ctx context.Context, repo git.RepositoryExecutor) (map[string][]git.ReferenceName, error) {
-	cmd, err := repo.Exec(ctx, git.SubCmd{
-		Name:  "for-each-ref",
-		Flags: []git.Option{git.ValueFlag{Name: "--format", Value: "%(objectname) %(refname)"}},
-		Args:  git.InternalRefPrefixes[:],
-	})
+// updateRefs applies c.mode to every internal ref pointing at oldSHA,
+// deleting or rewriting them to point at newSHA as appropriate, and reports
+// the action actually taken.
+func (c *Cleaner) updateRefs(ctx context.Context, oldSHA, newSHA string, refs []git.ReferenceName) (CleanerAction, error) {
+	logger := ctxlogrus.Extract(c.ctx).WithFields(log.Fields{"sha": oldSHA, "refs": refs})
+
+	if c.mode == ModeDelete || newSHA == zeroOID(c.hexLen) {
+		logger.Info("removing internal references")
+
+		for _, ref := range refs {
+			if err := c.updater.Delete(ref); err != nil {
+				return ActionNone, err
+			}
+		}
+
+		return ActionDeleted, nil
+	}
+
+	exists, err := c.objectExists(ctx, newSHA)
 	if err != nil {
-		return nil, err
+		return ActionNone, err
 	}
 
-	logger := ctxlogrus.Extract(ctx)
-	out := make(map[string][]git.ReferenceName)
-	scanner := bufio.NewScanner(cmd)
+	if !exists {
+		if c.mode != ModeRewriteOrDelete {
+			return ActionNone, fmt.Errorf("object map references missing object %q", newSHA)
+		}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.SplitN(line, " ", 2)
-		if len(parts) != 2 || len(parts[0]) != 40 {
-			logger.WithFields(log.Fields{"line": line}).Warn("failed to parse git refs")
-			return nil, fmt.Errorf("failed to parse git refs")
+		logger.Info("removing internal references to missing object")
+
+		for _, ref := range refs {
+			if err := c.updater.Delete(ref); err != nil {
+				return ActionNone, err
+			}
 		}
 
-		out[parts[0]] = append(out[parts[0]], git.ReferenceName(parts[1]))
+		return ActionDeleted, nil
 	}
 
-	if err := cmd.Wait(); err != nil {
-		return nil, err
+	logger.WithField("new_sha", newSHA).Info("rewriting internal references")
+
+	for _, ref := range refs {
+		if err := c.updater.Update(ref, newSHA, oldSHA); err != nil {
+			return ActionNone, err
+		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	return ActionRewritten, nil
+}
+
+// objectExists reports whether oid names an object that exists in the
+// repository. This is a pragmatic stand-in for true reachability: walking
+// the whole ref graph for every rewritten entry would be far too expensive,
+// and an object map generated from the repository's own history will only
+// ever point at objects that are reachable once the rewrite lands.
+func (c *Cleaner) objectExists(ctx context.Context, oid string) (bool, error) {
+	cmd, err := c.repo.Exec(ctx, git.SubCmd{
+		Name:  "cat-file",
+		Flags: []git.Option{git.Flag{Name: "-e"}},
+		Args:  []string{oid},
+	})
+	if err != nil {
+		return false, err
 	}
 
-	return out, nil
-}
\ No newline at end of file
+	if err := cmd.Wait(); err != nil {
+		return false, nil
+	}
+
+	return true, nil
+}
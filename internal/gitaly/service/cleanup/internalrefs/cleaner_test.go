@@ -0,0 +1,157 @@
+package internalrefs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/git/gittest"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/git/localrepo"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/testhelper"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/testhelper/testcfg"
+)
+
+func TestCleaner_sha256(t *testing.T) {
+	t.Parallel()
+	ctx := testhelper.Context(t)
+
+	cfg, repoProto, repoPath := testcfg.BuildWithRepo(t, testcfg.WithRepositoryOptions(
+		gittest.InitRepoOpts{ObjectFormat: "sha256"},
+	))
+	repo := localrepo.NewTestRepo(t, cfg, repoProto)
+
+	oldOID := gittest.WriteCommit(t, cfg, repoPath, gittest.WithBranch("main"))
+	newOID := gittest.WriteCommit(t, cfg, repoPath, gittest.WithParents(oldOID))
+
+	internalRef := "refs/keep-around/" + oldOID.String()
+	gittest.Exec(t, cfg, "-C", repoPath, "update-ref", internalRef, oldOID.String())
+
+	var seen []string
+	cleaner, err := NewCleaner(ctx, repo, ModeDelete, func(ctx context.Context, old, new string, isInternalRef bool, action CleanerAction) error {
+		seen = append(seen, old)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 64, cleaner.hexLen)
+
+	objectMap := strings.NewReader(oldOID.String() + " " + newOID.String() + "\n")
+	require.NoError(t, cleaner.ApplyObjectMap(ctx, objectMap))
+	require.Equal(t, []string{oldOID.String()}, seen)
+
+	refs := gittest.Exec(t, cfg, "-C", repoPath, "for-each-ref", internalRef)
+	require.Empty(t, strings.TrimSpace(string(refs)))
+}
+
+func TestCleaner_rewriteMode(t *testing.T) {
+	t.Parallel()
+	ctx := testhelper.Context(t)
+
+	t.Run("filter-repo commit map rewrites internal ref", func(t *testing.T) {
+		t.Parallel()
+
+		cfg, repoProto, repoPath := testcfg.BuildWithRepo(t)
+		repo := localrepo.NewTestRepo(t, cfg, repoProto)
+
+		oldOID := gittest.WriteCommit(t, cfg, repoPath, gittest.WithBranch("main"))
+		newOID := gittest.WriteCommit(t, cfg, repoPath, gittest.WithParents(oldOID))
+
+		internalRef := "refs/keep-around/" + oldOID.String()
+		gittest.Exec(t, cfg, "-C", repoPath, "update-ref", internalRef, oldOID.String())
+
+		var actions []CleanerAction
+		cleaner, err := NewCleaner(ctx, repo, ModeRewrite, func(ctx context.Context, old, new string, isInternalRef bool, action CleanerAction) error {
+			actions = append(actions, action)
+			return nil
+		})
+		require.NoError(t, err)
+
+		// filter-repo's commit-map has a header line that must be skipped.
+		objectMap := strings.NewReader(
+			"old                                      new\n" +
+				oldOID.String() + " " + newOID.String() + "\n",
+		)
+		require.NoError(t, cleaner.ApplyObjectMap(ctx, objectMap))
+		require.Equal(t, []CleanerAction{ActionRewritten}, actions)
+
+		ref := gittest.Exec(t, cfg, "-C", repoPath, "for-each-ref", "--format=%(objectname)", internalRef)
+		require.Equal(t, newOID.String(), strings.TrimSpace(string(ref)))
+	})
+
+	t.Run("BFG object map deletes ref mapped to the zero OID", func(t *testing.T) {
+		t.Parallel()
+
+		cfg, repoProto, repoPath := testcfg.BuildWithRepo(t)
+		repo := localrepo.NewTestRepo(t, cfg, repoProto)
+
+		oldOID := gittest.WriteCommit(t, cfg, repoPath, gittest.WithBranch("main"))
+
+		internalRef := "refs/keep-around/" + oldOID.String()
+		gittest.Exec(t, cfg, "-C", repoPath, "update-ref", internalRef, oldOID.String())
+
+		var actions []CleanerAction
+		cleaner, err := NewCleaner(ctx, repo, ModeRewrite, func(ctx context.Context, old, new string, isInternalRef bool, action CleanerAction) error {
+			actions = append(actions, action)
+			return nil
+		})
+		require.NoError(t, err)
+
+		// BFG's object-id-map.old-new.txt uses the zero OID to mean "object
+		// removed", which must always be deleted rather than rewritten.
+		objectMap := strings.NewReader(oldOID.String() + " " + strings.Repeat("0", cleaner.hexLen) + "\n")
+		require.NoError(t, cleaner.ApplyObjectMap(ctx, objectMap))
+		require.Equal(t, []CleanerAction{ActionDeleted}, actions)
+
+		refs := gittest.Exec(t, cfg, "-C", repoPath, "for-each-ref", internalRef)
+		require.Empty(t, strings.TrimSpace(string(refs)))
+	})
+
+	t.Run("ModeRewrite rejects an unreachable new OID", func(t *testing.T) {
+		t.Parallel()
+
+		cfg, repoProto, repoPath := testcfg.BuildWithRepo(t)
+		repo := localrepo.NewTestRepo(t, cfg, repoProto)
+
+		oldOID := gittest.WriteCommit(t, cfg, repoPath, gittest.WithBranch("main"))
+		unreachableOID := gittest.WriteCommit(t, cfg, repoPath, gittest.WithParents(oldOID), gittest.WithBranch("to-be-deleted"))
+		gittest.Exec(t, cfg, "-C", repoPath, "branch", "-D", "to-be-deleted")
+		gittest.Exec(t, cfg, "-C", repoPath, "reflog", "expire", "--expire=now", "--all")
+		gittest.Exec(t, cfg, "-C", repoPath, "prune", "--expire=now")
+
+		internalRef := "refs/keep-around/" + oldOID.String()
+		gittest.Exec(t, cfg, "-C", repoPath, "update-ref", internalRef, oldOID.String())
+
+		cleaner, err := NewCleaner(ctx, repo, ModeRewrite, nil)
+		require.NoError(t, err)
+
+		objectMap := strings.NewReader(oldOID.String() + " " + unreachableOID.String() + "\n")
+		require.Error(t, cleaner.ApplyObjectMap(ctx, objectMap))
+	})
+
+	t.Run("ModeRewriteOrDelete falls back to deleting an unreachable new OID", func(t *testing.T) {
+		t.Parallel()
+
+		cfg, repoProto, repoPath := testcfg.BuildWithRepo(t)
+		repo := localrepo.NewTestRepo(t, cfg, repoProto)
+
+		oldOID := gittest.WriteCommit(t, cfg, repoPath, gittest.WithBranch("main"))
+		unreachableOID := gittest.WriteCommit(t, cfg, repoPath, gittest.WithParents(oldOID), gittest.WithBranch("to-be-deleted"))
+		gittest.Exec(t, cfg, "-C", repoPath, "branch", "-D", "to-be-deleted")
+		gittest.Exec(t, cfg, "-C", repoPath, "reflog", "expire", "--expire=now", "--all")
+		gittest.Exec(t, cfg, "-C", repoPath, "prune", "--expire=now")
+
+		internalRef := "refs/keep-around/" + oldOID.String()
+		gittest.Exec(t, cfg, "-C", repoPath, "update-ref", internalRef, oldOID.String())
+
+		var actions []CleanerAction
+		cleaner, err := NewCleaner(ctx, repo, ModeRewriteOrDelete, func(ctx context.Context, old, new string, isInternalRef bool, action CleanerAction) error {
+			actions = append(actions, action)
+			return nil
+		})
+		require.NoError(t, err)
+
+		objectMap := strings.NewReader(oldOID.String() + " " + unreachableOID.String() + "\n")
+		require.NoError(t, cleaner.ApplyObjectMap(ctx, objectMap))
+		require.Equal(t, []CleanerAction{ActionDeleted}, actions)
+	})
+}
@@ -0,0 +1,355 @@
+package internalrefs
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus/ctxlogrus"
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/git"
+)
+
+// defaultLookupThreshold is the number of internal refs at which
+// buildLookupTable spills the SHA -> ref data to disk instead of keeping it
+// all in memory. It also doubles as the external-sort chunk size: each
+// sorted run written to disk holds at most this many entries, which bounds
+// the resident set regardless of how many refs the repository has.
+const defaultLookupThreshold = 1_000_000
+
+// lookupTable answers "which internal refs point at this OID?" for the
+// object map entries processed by Cleaner. It is implemented either fully
+// in-memory (mapLookupTable) or, for repositories with huge numbers of
+// internal refs, by a sorted on-disk file plus a Bloom filter front-end
+// (diskLookupTable).
+type lookupTable interface {
+	// Lookup returns the internal refs pointing at oid. A nil/empty slice
+	// means no internal ref points at oid.
+	Lookup(oid string) ([]git.ReferenceName, error)
+	// Close releases any resources (e.g. temp files) held by the table.
+	Close() error
+}
+
+// refEntry is one row of the SHA -> ref lookup data, as read off
+// `for-each-ref`.
+type refEntry struct {
+	oid string
+	ref git.ReferenceName
+}
+
+// mapLookupTable is the original, fully in-memory lookup table. It remains
+// the default for repositories whose internal ref count is below
+// buildLookupTable's threshold, since it is simpler and faster than the
+// disk-backed implementation.
+type mapLookupTable map[string][]git.ReferenceName
+
+func (t mapLookupTable) Lookup(oid string) ([]git.ReferenceName, error) {
+	return t[oid], nil
+}
+
+func (t mapLookupTable) Close() error { return nil }
+
+// buildLookupTable streams the repository's internal refs and builds a
+// lookupTable mapping OID -> refs pointing at it. Below threshold entries,
+// the whole table is kept in memory. At or above it, entries are spilled to
+// disk as sorted runs and merged into a single sorted file, fronted by a
+// Bloom filter so that processEntry's common case - an OID with no internal
+// refs - never has to touch disk.
+func buildLookupTable(ctx context.Context, repo git.RepositoryExecutor, hexLen int, threshold int) (lookupTable, error) {
+	if threshold <= 0 {
+		threshold = defaultLookupThreshold
+	}
+
+	cmd, err := repo.Exec(ctx, git.SubCmd{
+		Name:  "for-each-ref",
+		Flags: []git.Option{git.ValueFlag{Name: "--format", Value: "%(objectname) %(refname)"}},
+		Args:  git.InternalRefPrefixes[:],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger := ctxlogrus.Extract(ctx)
+	scanner := bufio.NewScanner(cmd)
+
+	var (
+		buffer       []refEntry
+		chunkFiles   []*os.File
+		totalEntries int
+	)
+
+	flushChunk := func() error {
+		if len(buffer) == 0 {
+			return nil
+		}
+
+		sort.Slice(buffer, func(i, j int) bool { return buffer[i].oid < buffer[j].oid })
+
+		f, err := os.CreateTemp("", "gitaly-internalrefs-chunk-*")
+		if err != nil {
+			return err
+		}
+
+		w := bufio.NewWriter(f)
+		for _, entry := range buffer {
+			if _, err := fmt.Fprintf(w, "%s %s\n", entry.oid, entry.ref); err != nil {
+				return err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		chunkFiles = append(chunkFiles, f)
+		buffer = buffer[:0]
+
+		return nil
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 || len(parts[0]) != hexLen {
+			logger.WithFields(log.Fields{"line": line}).Warn("failed to parse git refs")
+			closeChunkFiles(chunkFiles)
+			return nil, fmt.Errorf("failed to parse git refs")
+		}
+
+		buffer = append(buffer, refEntry{oid: parts[0], ref: git.ReferenceName(parts[1])})
+		totalEntries++
+
+		if len(buffer) >= threshold {
+			if err := flushChunk(); err != nil {
+				closeChunkFiles(chunkFiles)
+				return nil, err
+			}
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		closeChunkFiles(chunkFiles)
+		return nil, err
+	}
+	if err := scanner.Err(); err != nil {
+		closeChunkFiles(chunkFiles)
+		return nil, err
+	}
+
+	// Nothing was ever spilled: the whole ref set fit under threshold, so
+	// the simple in-memory table is the fastest option and we never
+	// touched disk.
+	if len(chunkFiles) == 0 {
+		out := make(mapLookupTable, len(buffer))
+		for _, entry := range buffer {
+			out[entry.oid] = append(out[entry.oid], entry.ref)
+		}
+		return out, nil
+	}
+
+	if err := flushChunk(); err != nil {
+		closeChunkFiles(chunkFiles)
+		return nil, err
+	}
+
+	logger.WithFields(log.Fields{
+		"chunks":  len(chunkFiles),
+		"entries": totalEntries,
+	}).Info("internal refs exceed in-memory threshold, building disk-backed lookup table")
+
+	return buildDiskLookupTable(chunkFiles, totalEntries)
+}
+
+func closeChunkFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+}
+
+// countingWriter tracks the number of bytes written through it so that
+// buildDiskLookupTable can record each unique OID's byte offset in the
+// merged output file as it is written, without a second pass.
+type countingWriter struct {
+	w   io.Writer
+	off int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.off += int64(n)
+	return n, err
+}
+
+// chunkReader is one input to the k-way merge: a sorted chunk file plus the
+// entry most recently read from it.
+type chunkReader struct {
+	scanner *bufio.Scanner
+	file    *os.File
+	current refEntry
+}
+
+// chunkHeap is a min-heap of chunkReaders ordered by their current entry's
+// OID, implementing the merge step of an external mergesort.
+type chunkHeap []*chunkReader
+
+func (h chunkHeap) Len() int            { return len(h) }
+func (h chunkHeap) Less(i, j int) bool  { return h[i].current.oid < h[j].current.oid }
+func (h chunkHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *chunkHeap) Push(x interface{}) { *h = append(*h, x.(*chunkReader)) }
+func (h *chunkHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// buildDiskLookupTable performs the merge phase of the external sort: it
+// k-way merges the sorted chunk files into a single sorted file, while
+// recording a Bloom filter and a sparse (one entry per unique OID) offset
+// index so that Lookup can jump straight to the right place in the merged
+// file instead of scanning it. totalEntries is an upper bound on the number
+// of distinct OIDs, used to size the Bloom filter.
+func buildDiskLookupTable(chunkFiles []*os.File, totalEntries int) (_ lookupTable, err error) {
+	defer closeChunkFiles(chunkFiles)
+
+	merged, err := os.CreateTemp("", "gitaly-internalrefs-merged-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = merged.Close()
+			_ = os.Remove(merged.Name())
+		}
+	}()
+
+	h := make(chunkHeap, 0, len(chunkFiles))
+	for _, f := range chunkFiles {
+		r := &chunkReader{scanner: bufio.NewScanner(f), file: f}
+		if r.advance() {
+			h = append(h, r)
+		}
+	}
+	heap.Init(&h)
+
+	bloom := newBloomFilter(totalEntries, 0.01)
+	var indexOIDs []string
+	var indexOffsets []int64
+
+	out := &countingWriter{w: bufio.NewWriterSize(merged, 64*1024)}
+	writer := out.w.(*bufio.Writer)
+
+	var lastOID string
+	haveLastOID := false
+
+	for h.Len() > 0 {
+		r := h[0]
+		entry := r.current
+
+		if !haveLastOID || entry.oid != lastOID {
+			bloom.Add(entry.oid)
+			indexOIDs = append(indexOIDs, entry.oid)
+			indexOffsets = append(indexOffsets, out.off)
+			lastOID = entry.oid
+			haveLastOID = true
+		}
+
+		if _, err := fmt.Fprintf(out, "%s %s\n", entry.oid, entry.ref); err != nil {
+			return nil, err
+		}
+
+		if r.advance() {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := merged.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return &diskLookupTable{
+		file:         merged,
+		bloom:        bloom,
+		indexOIDs:    indexOIDs,
+		indexOffsets: indexOffsets,
+	}, nil
+}
+
+// advance reads the chunk's next line into r.current, returning false once
+// the chunk is exhausted.
+func (r *chunkReader) advance() bool {
+	if !r.scanner.Scan() {
+		return false
+	}
+
+	line := r.scanner.Text()
+	parts := strings.SplitN(line, " ", 2)
+	r.current = refEntry{oid: parts[0], ref: git.ReferenceName(parts[1])}
+	return true
+}
+
+// diskLookupTable is the disk-backed lookupTable used once a repository's
+// internal ref count crosses buildLookupTable's threshold. oids are looked
+// up via a Bloom filter to rule out the common absent case, falling back to
+// a binary search over the sparse offset index and a bounded scan of the
+// merged, OID-sorted file for the rest.
+type diskLookupTable struct {
+	file         *os.File
+	bloom        *bloomFilter
+	indexOIDs    []string
+	indexOffsets []int64
+}
+
+func (t *diskLookupTable) Lookup(oid string) ([]git.ReferenceName, error) {
+	if !t.bloom.MaybeContains(oid) {
+		return nil, nil
+	}
+
+	i := sort.SearchStrings(t.indexOIDs, oid)
+	if i >= len(t.indexOIDs) || t.indexOIDs[i] != oid {
+		// Bloom filter false positive: oid really isn't present.
+		return nil, nil
+	}
+
+	if _, err := t.file.Seek(t.indexOffsets[i], io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var refs []git.ReferenceName
+	scanner := bufio.NewScanner(t.file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 || parts[0] != oid {
+			break
+		}
+		refs = append(refs, git.ReferenceName(parts[1]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+func (t *diskLookupTable) Close() error {
+	name := t.file.Name()
+	if err := t.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,80 @@
+package internalrefs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/git/gittest"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/git/localrepo"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/testhelper"
+	"gitlab.com/gitlab-org/gitaly/v14/internal/testhelper/testcfg"
+)
+
+// TestCleaner_diskBackedLookupTable exercises the disk-backed lookup table
+// by forcing WithLookupThreshold down to a handful of refs, well below what
+// a real repository would need to spill to disk, without requiring this
+// test to actually create millions of refs.
+func TestCleaner_diskBackedLookupTable(t *testing.T) {
+	t.Parallel()
+	ctx := testhelper.Context(t)
+
+	cfg, repoProto, repoPath := testcfg.BuildWithRepo(t)
+	repo := localrepo.NewTestRepo(t, cfg, repoProto)
+
+	oldOID := gittest.WriteCommit(t, cfg, repoPath, gittest.WithBranch("main"))
+	newOID := gittest.WriteCommit(t, cfg, repoPath, gittest.WithParents(oldOID))
+
+	keepAround := "refs/keep-around/" + oldOID.String()
+	mergeRequestRef := "refs/merge-requests/1/head"
+	gittest.Exec(t, cfg, "-C", repoPath, "update-ref", keepAround, oldOID.String())
+	gittest.Exec(t, cfg, "-C", repoPath, "update-ref", mergeRequestRef, oldOID.String())
+
+	// Unrelated internal refs to pad the ref count past the threshold and
+	// force at least one chunk to spill to disk.
+	for i := 0; i < 5; i++ {
+		unrelated := gittest.WriteCommit(t, cfg, repoPath, gittest.WithParents(newOID))
+		gittest.Exec(t, cfg, "-C", repoPath, "update-ref", "refs/keep-around/"+unrelated.String(), unrelated.String())
+	}
+
+	var seen []string
+	var actions []CleanerAction
+	cleaner, err := NewCleaner(ctx, repo, ModeRewrite, func(ctx context.Context, old, new string, isInternalRef bool, action CleanerAction) error {
+		seen = append(seen, old)
+		actions = append(actions, action)
+		return nil
+	}, WithLookupThreshold(2))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, cleaner.Close()) }()
+
+	_, ok := cleaner.table.(*diskLookupTable)
+	require.True(t, ok, "expected disk-backed lookup table above the configured threshold")
+
+	objectMap := strings.NewReader(oldOID.String() + " " + newOID.String() + "\n")
+	require.NoError(t, cleaner.ApplyObjectMap(ctx, objectMap))
+	require.Equal(t, []string{oldOID.String()}, seen)
+	require.Equal(t, []CleanerAction{ActionRewritten}, actions)
+
+	for _, ref := range []string{keepAround, mergeRequestRef} {
+		out := gittest.Exec(t, cfg, "-C", repoPath, "for-each-ref", "--format=%(objectname)", ref)
+		require.Equal(t, newOID.String(), strings.TrimSpace(string(out)))
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	t.Parallel()
+
+	present := []string{"abc123", "def456", "ghi789"}
+
+	filter := newBloomFilter(len(present), 0.01)
+	for _, oid := range present {
+		filter.Add(oid)
+	}
+
+	// A Bloom filter never has false negatives: everything added must be
+	// reported present.
+	for _, oid := range present {
+		require.True(t, filter.MaybeContains(oid), "expected %q to be reported present", oid)
+	}
+}
@@ -0,0 +1,253 @@
+package limithandler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// backpressureSignal reports whether the server is currently under enough
+// load that an adaptiveLimiter should shrink its concurrency ceiling rather
+// than grow it. A non-nil error means the signal could not be read and
+// should be ignored for this interval rather than treated as backpressure.
+type backpressureSignal func() (bool, error)
+
+// anySignal combines multiple backpressure signals: the combined signal
+// reports backpressure if any one of them does, and is silent (false, nil)
+// only once every signal has been read without a pressure response. Read
+// errors are treated the same as "no pressure" for that one signal, so a
+// single unreadable signal (e.g. no cgroup support on this host) does not
+// block the others from being acted on.
+func anySignal(signals ...backpressureSignal) backpressureSignal {
+	return func() (bool, error) {
+		for _, signal := range signals {
+			if signal == nil {
+				continue
+			}
+
+			underPressure, err := signal()
+			if err != nil {
+				continue
+			}
+			if underPressure {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// adaptiveLimiterConfig configures an adaptiveLimiter's AIMD control loop.
+type adaptiveLimiterConfig struct {
+	// Min and Max bound the limit the control loop will settle on.
+	Min, Max int
+	// Initial is the limit the control loop starts at, before its first
+	// observation interval elapses.
+	Initial int
+	// Interval is how often the control loop re-evaluates Backpressure
+	// and adjusts the limit.
+	Interval time.Duration
+	// Backpressure reports whether the server is under enough load to
+	// shrink the limit this interval. A nil Backpressure never reports
+	// pressure, so unless QueueLengthThreshold is set the limit only ever
+	// grows to Max.
+	Backpressure backpressureSignal
+	// QueueLengthThreshold additionally reports backpressure once the
+	// limiter's own in-flight count reaches it. It is combined with
+	// Backpressure rather than passed in directly, since it needs a
+	// reference to the adaptiveLimiter being constructed.
+	QueueLengthThreshold int
+
+	// decreaseFactor is the AIMD backoff multiplier (β). Zero, or any
+	// value outside (0, 1), falls back to defaultDecreaseFactor; nothing in
+	// this package sets it.
+	decreaseFactor float64
+}
+
+const defaultDecreaseFactor = 0.75
+
+// adaptiveLimiter is a ConcurrencyLimiter whose ceiling is adjusted by an
+// AIMD control loop: each interval, it multiplicatively decreases the limit
+// under backpressure, or additively increases it otherwise. The limit itself
+// is guarded by mu; Acquire calls block while the number
+// in flight is at or above the current limit, and Release wakes the next
+// waiter.
+type adaptiveLimiter struct {
+	cfg adaptiveLimiterConfig
+
+	mu       sync.Mutex
+	limit    int
+	inFlight int
+	waiters  []chan struct{}
+
+	stop chan struct{}
+}
+
+func newAdaptiveLimiter(cfg adaptiveLimiterConfig) *adaptiveLimiter {
+	if cfg.Min <= 0 {
+		cfg.Min = 1
+	}
+	if cfg.Max < cfg.Min {
+		cfg.Max = cfg.Min
+	}
+	if cfg.Initial < cfg.Min {
+		cfg.Initial = cfg.Min
+	}
+	if cfg.Initial > cfg.Max {
+		cfg.Initial = cfg.Max
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.decreaseFactor <= 0 || cfg.decreaseFactor >= 1 {
+		cfg.decreaseFactor = defaultDecreaseFactor
+	}
+
+	l := &adaptiveLimiter{
+		cfg:   cfg,
+		limit: cfg.Initial,
+		stop:  make(chan struct{}),
+	}
+
+	if queueSignal := queueLengthSignal(cfg.QueueLengthThreshold, l.currentInFlight); queueSignal != nil {
+		l.cfg.Backpressure = anySignal(l.cfg.Backpressure, queueSignal)
+	}
+
+	go l.run()
+
+	return l
+}
+
+// currentInFlight reports the number of callers currently admitted by the
+// limiter. It exists so QueueLengthThreshold can be checked against the
+// limiter's own state without a circular reference at construction time.
+func (l *adaptiveLimiter) currentInFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// run is the AIMD control loop: once per configured interval, it grows or
+// shrinks the limit and wakes any waiters that the new limit admits.
+func (l *adaptiveLimiter) run() {
+	ticker := time.NewTicker(l.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.adjust()
+		}
+	}
+}
+
+func (l *adaptiveLimiter) adjust() {
+	var underPressure bool
+	if l.cfg.Backpressure != nil {
+		var err error
+		underPressure, err = l.cfg.Backpressure()
+		if err != nil {
+			underPressure = false
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if underPressure {
+		l.limit = maxInt(l.cfg.Min, int(float64(l.limit)*l.cfg.decreaseFactor))
+	} else {
+		l.limit = minInt(l.cfg.Max, l.limit+1)
+	}
+
+	l.wakeWaitersLocked()
+}
+
+// wakeWaitersLocked wakes as many waiters as the current limit now admits.
+// l.mu must be held.
+func (l *adaptiveLimiter) wakeWaitersLocked() {
+	for len(l.waiters) > 0 && l.inFlight < l.limit {
+		waiter := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		l.inFlight++
+		close(waiter)
+	}
+}
+
+// Acquire blocks until the in-flight count is below the current limit, or
+// ctx is done.
+func (l *adaptiveLimiter) Acquire(ctx context.Context) (func(), error) {
+	l.mu.Lock()
+	if l.inFlight < l.limit {
+		l.inFlight++
+		l.mu.Unlock()
+		return l.release, nil
+	}
+
+	waiter := make(chan struct{})
+	l.waiters = append(l.waiters, waiter)
+	l.mu.Unlock()
+
+	select {
+	case <-waiter:
+		return l.release, nil
+	case <-ctx.Done():
+		l.abandon(waiter)
+		return nil, ctx.Err()
+	}
+}
+
+// abandon handles ctx being cancelled while Acquire was waiting on waiter.
+// If waiter is still queued, it is simply removed: no slot was ever charged
+// to it. But wakeWaitersLocked can pop a waiter off the queue, increment
+// inFlight, and close its channel in the same instant ctx is cancelled,
+// racing the select in Acquire; if that happens, waiter won't be found here
+// because it is already gone from the queue, yet a slot was charged to it
+// and its caller is about to return ctx.Err() without ever calling release.
+// Treat "not found" as exactly that case and release the slot ourselves,
+// or it leaks for the lifetime of the limiter.
+func (l *adaptiveLimiter) abandon(waiter chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, w := range l.waiters {
+		if w == waiter {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return
+		}
+	}
+
+	l.inFlight--
+	l.wakeWaitersLocked()
+}
+
+func (l *adaptiveLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	l.wakeWaitersLocked()
+}
+
+// Stop halts the limiter's control loop. It is not part of the
+// ConcurrencyLimiter interface since callers obtain limiters indirectly,
+// through methodLimiter, and are not expected to tear individual ones down.
+func (l *adaptiveLimiter) Stop() {
+	close(l.stop)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
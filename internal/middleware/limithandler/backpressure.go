@@ -0,0 +1,103 @@
+package limithandler
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cpuLoadAverageSignal reports backpressure once the 1-minute load average
+// exceeds threshold. A threshold of 0 or less disables the signal.
+func cpuLoadAverageSignal(threshold float64) backpressureSignal {
+	if threshold <= 0 {
+		return nil
+	}
+
+	return func() (bool, error) {
+		loadAvg, err := readLoadAverage("/proc/loadavg")
+		if err != nil {
+			return false, err
+		}
+		return loadAvg >= threshold, nil
+	}
+}
+
+func readLoadAverage(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return 0, scanner.Err()
+	}
+
+	fields := strings.Fields(scanner.Text())
+	if len(fields) == 0 {
+		return 0, strconv.ErrSyntax
+	}
+
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// cgroupMemorySignal reports backpressure once a cgroup v2 memory
+// controller's usage exceeds threshold as a fraction of its limit (e.g. 0.9
+// for 90%). A threshold of 0 or less disables the signal. If the cgroup v2
+// memory files cannot be read (e.g. in a test sandbox, or on a host still
+// using cgroup v1), the signal returns the read error on every interval;
+// anySignal skips a signal that errs, so this counts as no pressure.
+func cgroupMemorySignal(threshold float64) backpressureSignal {
+	if threshold <= 0 {
+		return nil
+	}
+
+	const (
+		currentPath = "/sys/fs/cgroup/memory.current"
+		maxPath     = "/sys/fs/cgroup/memory.max"
+	)
+
+	return func() (bool, error) {
+		current, err := readCgroupValue(currentPath)
+		if err != nil {
+			return false, err
+		}
+
+		limit, err := readCgroupValue(maxPath)
+		if err != nil || limit <= 0 {
+			// memory.max reads "max" when the cgroup is unbounded: there is
+			// no ceiling to be under pressure against.
+			return false, err
+		}
+
+		return float64(current)/float64(limit) >= threshold, nil
+	}
+}
+
+func readCgroupValue(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// queueLengthSignal reports backpressure once inFlight() reaches threshold.
+// A threshold of 0 or less disables the signal.
+func queueLengthSignal(threshold int, inFlight func() int) backpressureSignal {
+	if threshold <= 0 {
+		return nil
+	}
+
+	return func() (bool, error) {
+		return inFlight() >= threshold, nil
+	}
+}
@@ -0,0 +1,179 @@
+// Package limithandler provides gRPC interceptors that bound the number of
+// in-flight requests Gitaly will serve at once, per RPC and per repository.
+// This protects the server from being overwhelmed by expensive operations
+// such as pool repacks or pack-objects on large pools.
+package limithandler
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+
+	"gitlab.com/gitlab-org/gitaly/v14/internal/gitaly/config"
+	"gitlab.com/gitlab-org/gitaly/v14/proto/go/gitalypb"
+)
+
+// ConcurrencyLimiter bounds the number of concurrent callers admitted for a
+// single key (typically an RPC/repository pair). Acquire blocks until a slot
+// is available, or ctx is done, and returns a function that must be called
+// to release the slot.
+type ConcurrencyLimiter interface {
+	Acquire(ctx context.Context) (func(), error)
+}
+
+// KeyFunc derives the key a request is limited by from the incoming gRPC
+// call. fullMethod is the fully qualified method name (as reported by gRPC),
+// and req is the unmarshalled request message.
+type KeyFunc func(ctx context.Context, fullMethod string, req interface{}) string
+
+// repoScopedRequest is implemented by every generated request message that
+// carries a target repository, which is effectively all of them.
+type repoScopedRequest interface {
+	GetRepository() *gitalypb.Repository
+}
+
+// LimitConcurrencyByRepo is a KeyFunc that limits concurrency per
+// RPC/repository pair: for unary RPCs, two different repositories never
+// share a limiter, even when they are both calling the same RPC. Requests
+// that do not carry a repository, and all streaming RPCs (see
+// StreamInterceptor), share a single, method-wide limiter.
+var LimitConcurrencyByRepo KeyFunc = func(_ context.Context, fullMethod string, req interface{}) string {
+	scoped, ok := req.(repoScopedRequest)
+	if !ok || scoped.GetRepository() == nil {
+		return fullMethod
+	}
+
+	repo := scoped.GetRepository()
+	return fullMethod + "/" + repo.GetStorageName() + "/" + repo.GetRelativePath()
+}
+
+// methodLimiter owns one ConcurrencyLimiter per key (e.g. per repository)
+// for a single RPC's configuration.
+type methodLimiter struct {
+	newLimiter func() ConcurrencyLimiter
+
+	mu       sync.Mutex
+	limiters map[string]ConcurrencyLimiter
+}
+
+func (m *methodLimiter) limiterFor(key string) ConcurrencyLimiter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	limiter, ok := m.limiters[key]
+	if !ok {
+		limiter = m.newLimiter()
+		m.limiters[key] = limiter
+	}
+
+	return limiter
+}
+
+// LimiterMiddleware is a pair of gRPC interceptors enforcing, for every RPC
+// that has a configured limiter, a ceiling on the number of in-flight calls.
+// RPCs without a configured limiter are left unbounded.
+type LimiterMiddleware struct {
+	keyer          KeyFunc
+	methodLimiters map[string]*methodLimiter
+}
+
+// Option configures a LimiterMiddleware at construction time.
+type Option func(*LimiterMiddleware, config.Cfg)
+
+// New builds a LimiterMiddleware that derives each request's limiting key
+// via keyer, configured by opts.
+func New(cfg config.Cfg, keyer KeyFunc, opts ...Option) *LimiterMiddleware {
+	m := &LimiterMiddleware{
+		keyer:          keyer,
+		methodLimiters: make(map[string]*methodLimiter),
+	}
+
+	for _, opt := range opts {
+		opt(m, cfg)
+	}
+
+	return m
+}
+
+// WithConcurrencyLimiters builds one methodLimiter per entry in
+// cfg.Concurrency. A Concurrency entry whose Adaptive flag is set gets an
+// AIMD-adjusted limit instead of the static MaxPerRepo ceiling, using these
+// additional config.Concurrency fields: MinLimit, MaxLimit, InitialLimit,
+// AdaptiveInterval, CPULoadThreshold, MemoryPressureThreshold and
+// QueueLengthThreshold.
+var WithConcurrencyLimiters Option = func(m *LimiterMiddleware, cfg config.Cfg) {
+	for _, concurrencyCfg := range cfg.Concurrency {
+		concurrencyCfg := concurrencyCfg
+
+		if concurrencyCfg.Adaptive {
+			backpressure := anySignal(
+				cpuLoadAverageSignal(concurrencyCfg.CPULoadThreshold),
+				cgroupMemorySignal(concurrencyCfg.MemoryPressureThreshold),
+			)
+
+			m.methodLimiters[concurrencyCfg.RPC] = &methodLimiter{
+				limiters: make(map[string]ConcurrencyLimiter),
+				newLimiter: func() ConcurrencyLimiter {
+					return newAdaptiveLimiter(adaptiveLimiterConfig{
+						Min:                  concurrencyCfg.MinLimit,
+						Max:                  concurrencyCfg.MaxLimit,
+						Initial:              concurrencyCfg.InitialLimit,
+						Interval:             concurrencyCfg.AdaptiveInterval,
+						Backpressure:         backpressure,
+						QueueLengthThreshold: concurrencyCfg.QueueLengthThreshold,
+					})
+				},
+			}
+			continue
+		}
+
+		maxPerRepo := concurrencyCfg.MaxPerRepo
+		m.methodLimiters[concurrencyCfg.RPC] = &methodLimiter{
+			limiters: make(map[string]ConcurrencyLimiter),
+			newLimiter: func() ConcurrencyLimiter {
+				return newSemaphoreLimiter(maxPerRepo)
+			},
+		}
+	}
+}
+
+func (m *LimiterMiddleware) acquire(ctx context.Context, fullMethod string, req interface{}) (func(), error) {
+	limiter, ok := m.methodLimiters[fullMethod]
+	if !ok {
+		return func() {}, nil
+	}
+
+	return limiter.limiterFor(m.keyer(ctx, fullMethod, req)).Acquire(ctx)
+}
+
+// UnaryInterceptor returns a grpc.UnaryServerInterceptor that enforces this
+// middleware's configured limits.
+func (m *LimiterMiddleware) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, err := m.acquire(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor returns a grpc.StreamServerInterceptor that enforces
+// this middleware's configured limits. Concurrency is bounded for the
+// lifetime of the whole stream, not per message. The limiting key is derived
+// with a nil request, as no message has been received when the stream is
+// admitted, so streams are limited per RPC, not per repository.
+func (m *LimiterMiddleware) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := m.acquire(stream.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		return handler(srv, stream)
+	}
+}
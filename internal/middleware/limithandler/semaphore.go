@@ -0,0 +1,24 @@
+package limithandler
+
+import "context"
+
+// semaphoreLimiter is the original, static ConcurrencyLimiter: it admits at
+// most max concurrent callers and blocks the rest until one releases.
+type semaphoreLimiter chan struct{}
+
+func newSemaphoreLimiter(max int) semaphoreLimiter {
+	if max <= 0 {
+		max = 1
+	}
+	return make(semaphoreLimiter, max)
+}
+
+func (s semaphoreLimiter) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case s <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return func() { <-s }, nil
+}
@@ -0,0 +1,65 @@
+// Package protoregistry records, per gRPC method, whether that method only
+// reads from a repository (an accessor) or changes it (a mutator), for use
+// by a caching middleware deciding which RPCs may invalidate cache entries.
+// No such middleware is present in this checkout, and nothing in
+// internal/cache reads this registry.
+package protoregistry
+
+// OpType classifies the effect an RPC has on a repository.
+type OpType int
+
+const (
+	// OpUnknown is returned for methods that have not been registered.
+	OpUnknown OpType = iota
+	// OpAccessor marks an RPC that only reads repository state.
+	OpAccessor
+	// OpMutator marks an RPC that may change repository state, and whose
+	// completion should invalidate any cache entries it could have
+	// affected.
+	OpMutator
+)
+
+// MethodInfo describes the caching-relevant properties of a single gRPC
+// method, identified by its fully qualified name (e.g.
+// "/gitaly.HookService/PostReceiveHook").
+type MethodInfo struct {
+	FullMethod string
+	Operation  OpType
+}
+
+// Registry maps fully qualified gRPC method names to their MethodInfo.
+type Registry struct {
+	methods map[string]MethodInfo
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{methods: make(map[string]MethodInfo)}
+}
+
+// RegisterMethodOpType records the OpType for fullMethod, overwriting any
+// previous registration.
+func (r *Registry) RegisterMethodOpType(fullMethod string, op OpType) {
+	r.methods[fullMethod] = MethodInfo{FullMethod: fullMethod, Operation: op}
+}
+
+// LookupMethod returns the MethodInfo registered for fullMethod. If nothing
+// was registered, it returns a MethodInfo with Operation set to OpUnknown.
+func (r *Registry) LookupMethod(fullMethod string) MethodInfo {
+	if info, ok := r.methods[fullMethod]; ok {
+		return info
+	}
+	return MethodInfo{FullMethod: fullMethod, Operation: OpUnknown}
+}
+
+// Default is the process-wide registry. The hook service's RPCs are
+// registered in it by this package's own init function below.
+var Default = NewRegistry()
+
+func init() {
+	// The hook service's RPCs are invoked by Git itself as part of a
+	// push, and are the only place a ref update is observed synchronously
+	// with the mutating RPC that triggered it. Both are mutators.
+	Default.RegisterMethodOpType("/gitaly.HookService/PostReceiveHook", OpMutator)
+	Default.RegisterMethodOpType("/gitaly.HookService/ReferenceTransactionHook", OpMutator)
+}
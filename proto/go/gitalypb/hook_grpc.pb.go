@@ -28,6 +28,10 @@ type HookServiceClient interface {
 	// PackObjectsHookWithSidechannel is an optimized version of PackObjectsHook that uses
 	// a unix socket side channel.
 	PackObjectsHookWithSidechannel(ctx context.Context, in *PackObjectsHookWithSidechannelRequest, opts ...grpc.CallOption) (*PackObjectsHookWithSidechannelResponse, error)
+	// ProcReceiveHook invokes the configured proc-receive processor for a
+	// push to a ref covered by receive.procReceiveRefs, forwarding the
+	// client's stdin and streaming the processor's stdout/stderr back.
+	ProcReceiveHook(ctx context.Context, opts ...grpc.CallOption) (HookService_ProcReceiveHookClient, error)
 }
 
 type hookServiceClient struct {
@@ -204,6 +208,37 @@ func (c *hookServiceClient) PackObjectsHookWithSidechannel(ctx context.Context,
 	return out, nil
 }
 
+func (c *hookServiceClient) ProcReceiveHook(ctx context.Context, opts ...grpc.CallOption) (HookService_ProcReceiveHookClient, error) {
+	stream, err := c.cc.NewStream(ctx, &HookService_ServiceDesc.Streams[5], "/gitaly.HookService/ProcReceiveHook", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &hookServiceProcReceiveHookClient{stream}
+	return x, nil
+}
+
+type HookService_ProcReceiveHookClient interface {
+	Send(*ProcReceiveHookRequest) error
+	Recv() (*ProcReceiveHookResponse, error)
+	grpc.ClientStream
+}
+
+type hookServiceProcReceiveHookClient struct {
+	grpc.ClientStream
+}
+
+func (x *hookServiceProcReceiveHookClient) Send(m *ProcReceiveHookRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *hookServiceProcReceiveHookClient) Recv() (*ProcReceiveHookResponse, error) {
+	m := new(ProcReceiveHookResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // HookServiceServer is the server API for HookService service.
 // All implementations must embed UnimplementedHookServiceServer
 // for forward compatibility
@@ -218,6 +253,10 @@ type HookServiceServer interface {
 	// PackObjectsHookWithSidechannel is an optimized version of PackObjectsHook that uses
 	// a unix socket side channel.
 	PackObjectsHookWithSidechannel(context.Context, *PackObjectsHookWithSidechannelRequest) (*PackObjectsHookWithSidechannelResponse, error)
+	// ProcReceiveHook invokes the configured proc-receive processor for a
+	// push to a ref covered by receive.procReceiveRefs, forwarding the
+	// client's stdin and streaming the processor's stdout/stderr back.
+	ProcReceiveHook(HookService_ProcReceiveHookServer) error
 	mustEmbedUnimplementedHookServiceServer()
 }
 
@@ -243,6 +282,9 @@ func (UnimplementedHookServiceServer) PackObjectsHook(HookService_PackObjectsHoo
 func (UnimplementedHookServiceServer) PackObjectsHookWithSidechannel(context.Context, *PackObjectsHookWithSidechannelRequest) (*PackObjectsHookWithSidechannelResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PackObjectsHookWithSidechannel not implemented")
 }
+func (UnimplementedHookServiceServer) ProcReceiveHook(HookService_ProcReceiveHookServer) error {
+	return status.Errorf(codes.Unimplemented, "method ProcReceiveHook not implemented")
+}
 func (UnimplementedHookServiceServer) mustEmbedUnimplementedHookServiceServer() {}
 
 // UnsafeHookServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -399,6 +441,32 @@ func _HookService_PackObjectsHookWithSidechannel_Handler(srv interface{}, ctx co
 	return interceptor(ctx, in, info, handler)
 }
 
+func _HookService_ProcReceiveHook_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(HookServiceServer).ProcReceiveHook(&hookServiceProcReceiveHookServer{stream})
+}
+
+type HookService_ProcReceiveHookServer interface {
+	Send(*ProcReceiveHookResponse) error
+	Recv() (*ProcReceiveHookRequest, error)
+	grpc.ServerStream
+}
+
+type hookServiceProcReceiveHookServer struct {
+	grpc.ServerStream
+}
+
+func (x *hookServiceProcReceiveHookServer) Send(m *ProcReceiveHookResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *hookServiceProcReceiveHookServer) Recv() (*ProcReceiveHookRequest, error) {
+	m := new(ProcReceiveHookRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // HookService_ServiceDesc is the grpc.ServiceDesc for HookService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -441,6 +509,12 @@ var HookService_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "ProcReceiveHook",
+			Handler:       _HookService_ProcReceiveHook_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "hook.proto",
 }